@@ -0,0 +1,62 @@
+package cookiestore
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// ContextJar is implemented by stores that talk to a backend. WithContext
+// returns a view of the store whose backend calls honour ctx, so a request's
+// deadline also bounds its cookie lookups.
+type ContextJar interface {
+	http.CookieJar
+	WithContext(ctx context.Context) http.CookieJar
+}
+
+// ErrCookieStore is a cookie store that reports failures, such as an
+// unreachable Redis server, instead of hiding them behind http.CookieJar.
+// NewCookieJar turns it into an http.CookieJar.
+type ErrCookieStore interface {
+	SetCookiesContext(ctx context.Context, u *url.URL, cookies []*http.Cookie) error
+	CookiesContext(ctx context.Context, u *url.URL) ([]*http.Cookie, error)
+}
+
+// ErrorHandler receives the errors of an ErrCookieStore used as an
+// http.CookieJar. op names the failed operation, such as "SetCookies" or
+// "Cookies"; u is nil for background work like FileCookieStore's flushes.
+type ErrorHandler func(ctx context.Context, op string, u *url.URL, err error)
+
+// NewCookieJar exposes store as an http.CookieJar. Errors are passed to
+// onError, or to LogErrors when it is nil; cookies returned along with an
+// error are still sent.
+func NewCookieJar(store ErrCookieStore, onError ErrorHandler) ContextJar {
+	if onError == nil {
+		onError = LogErrors
+	}
+	return &errJar{store: store, ctx: context.Background(), onError: onError}
+}
+
+type errJar struct {
+	store   ErrCookieStore
+	ctx     context.Context
+	onError ErrorHandler
+}
+
+func (j *errJar) WithContext(ctx context.Context) http.CookieJar {
+	return &errJar{store: j.store, ctx: ctx, onError: j.onError}
+}
+
+func (j *errJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	if err := j.store.SetCookiesContext(j.ctx, u, cookies); err != nil {
+		j.onError(j.ctx, "SetCookies", u, err)
+	}
+}
+
+func (j *errJar) Cookies(u *url.URL) []*http.Cookie {
+	cookies, err := j.store.CookiesContext(j.ctx, u)
+	if err != nil {
+		j.onError(j.ctx, "Cookies", u, err)
+	}
+	return cookies
+}
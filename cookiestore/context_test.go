@@ -0,0 +1,79 @@
+package cookiestore_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/takumi3488/twocker/cookiestore"
+)
+
+type failingStore struct {
+	err error
+	ctx context.Context
+}
+
+func (s *failingStore) SetCookiesContext(ctx context.Context, u *url.URL, cookies []*http.Cookie) error {
+	s.ctx = ctx
+	return s.err
+}
+
+func (s *failingStore) CookiesContext(ctx context.Context, u *url.URL) ([]*http.Cookie, error) {
+	s.ctx = ctx
+	return nil, s.err
+}
+
+type handledError struct {
+	op  string
+	url string
+	err error
+}
+
+func TestNewCookieJarErrorHandler(t *testing.T) {
+	storeErr := errors.New("backend unavailable")
+	store := &failingStore{err: storeErr}
+	var handled []handledError
+	jar := cookiestore.NewCookieJar(store, func(ctx context.Context, op string, u *url.URL, err error) {
+		handled = append(handled, handledError{op: op, url: u.String(), err: err})
+	})
+
+	u, _ := url.Parse("https://example.com/path")
+	jar.SetCookies(u, []*http.Cookie{{Name: "a", Value: "1"}})
+	require.Nil(t, jar.Cookies(u))
+	require.Equal(t, []handledError{
+		{op: "SetCookies", url: "https://example.com/path", err: storeErr},
+		{op: "Cookies", url: "https://example.com/path", err: storeErr},
+	}, handled)
+
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "request")
+	jar.WithContext(ctx).Cookies(u)
+	require.Equal(t, "request", store.ctx.Value(ctxKey{}), "WithContext should pass the context to the store")
+}
+
+func TestNewCookieJarDefaultHandler(t *testing.T) {
+	jar := cookiestore.NewCookieJar(&failingStore{err: errors.New("backend unavailable")}, nil)
+	u, _ := url.Parse("https://example.com/?token=secret")
+	require.NotPanics(t, func() {
+		jar.SetCookies(u, []*http.Cookie{{Name: "a", Value: "1"}})
+		jar.Cookies(u)
+	})
+}
+
+func TestInMemoryCookieStoreContextErrors(t *testing.T) {
+	store := cookiestore.NewInMemoryCookieStore()
+	noHost := &url.URL{Scheme: "http", Path: "/no-host"}
+	require.Error(t, store.SetCookiesContext(context.Background(), noHost, []*http.Cookie{{Name: "a", Value: "1"}}))
+	_, err := store.CookiesContext(context.Background(), noHost)
+	require.Error(t, err)
+
+	u, _ := url.Parse("http://example.com/")
+	require.NoError(t, store.SetCookiesContext(context.Background(), u, []*http.Cookie{{Name: "a", Value: "1"}}))
+	cookies, err := store.CookiesContext(context.Background(), u)
+	require.NoError(t, err)
+	require.Equal(t, "a=1", cookieHeader(cookies))
+}
@@ -0,0 +1,514 @@
+package cookiestore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// fileFormatVersion is the newest file version. Version 2 adds profiles and
+// is only written when the file has some, so that processes that only read
+// version 1 keep working until profiles are used.
+const fileFormatVersion = 2
+
+// FileCookieStore keeps cookies in memory and persists them to a JSON file.
+// Several processes may share the file: writes hold an exclusive lock on
+// path + ".lock" and merge the changes made since the last flush into the
+// current file contents, and changes made by other processes are picked up
+// when the file is modified. The file is replaced atomically, and a file
+// that cannot be parsed is moved aside to path + ".corrupt-<unix time>".
+type FileCookieStore struct {
+	*fileStore
+	// profile is the profile the store's methods act on.
+	profile string
+}
+
+// fileStore is the state shared by a FileCookieStore and its profiles.
+type fileStore struct {
+	path     string
+	lockPath string
+	interval time.Duration
+	mem      *InMemoryCookieStore
+	onError  ErrorHandler
+	logger   *slog.Logger
+
+	// mu guards pending, the changes not yet written, and the in-memory
+	// entries while they are replaced by the merged file contents.
+	mu      sync.Mutex
+	pending []fileOp
+
+	// flushMu serializes flushes within the process.
+	flushMu sync.Mutex
+	seen    fileStamp
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+type FileCookieStoreOption struct {
+	// FlushInterval is how often changes are written to the file and changes
+	// by other processes are read. When zero, every SetCookies call writes
+	// the file before returning.
+	FlushInterval time.Duration
+	// Logger receives store events, such as a corrupt file being moved
+	// aside, and errors unless WithErrorHandler is used. Events are logged
+	// with cookie counts but never cookie values. It defaults to
+	// slog.Default.
+	Logger *slog.Logger
+}
+
+// fileOp is a pending change to a profile: either a Set-Cookie value or the
+// removal of every entry selected by filter.
+type fileOp struct {
+	profile string
+	change  cookieChange
+	filter  entryFilter
+}
+
+type cookieFile struct {
+	Version  int                `json:"version"`
+	Cookies  []entry            `json:"cookies"`
+	Profiles map[string][]entry `json:"profiles,omitempty"`
+}
+
+// fileStamp identifies a version of the file written by any process. Every
+// write replaces the file, so a write that keeps the size within the
+// modification time granularity of the file system still changes the file
+// identity.
+type fileStamp struct {
+	info os.FileInfo
+}
+
+func (a fileStamp) equal(b fileStamp) bool {
+	if a.info == nil || b.info == nil {
+		return a.info == nil && b.info == nil
+	}
+	return os.SameFile(a.info, b.info) && a.info.Size() == b.info.Size() && a.info.ModTime().Equal(b.info.ModTime())
+}
+
+func NewFileCookieStore(path string, option *FileCookieStoreOption) (*FileCookieStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("file path cannot be empty")
+	}
+	if option == nil {
+		option = &FileCookieStoreOption{}
+	}
+	s := &FileCookieStore{fileStore: &fileStore{
+		path:     path,
+		lockPath: path + ".lock",
+		interval: option.FlushInterval,
+		logger:   loggerOrDefault(option.Logger),
+		mem:      NewInMemoryCookieStore(),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}}
+	if err := s.Flush(); err != nil {
+		return nil, err
+	}
+	if s.interval > 0 {
+		go s.flushLoop()
+	} else {
+		close(s.done)
+	}
+	return s, nil
+}
+
+// WithPublicSuffixList replaces DefaultPublicSuffixList. A nil list turns
+// the public suffix check off. It must be called before the store is used.
+func (s *FileCookieStore) WithPublicSuffixList(psl PublicSuffixList) *FileCookieStore {
+	s.mem.WithPublicSuffixList(psl)
+	return s
+}
+
+// WithErrorHandler sets the handler for errors of SetCookies, Cookies and
+// periodic flushes, which cannot return them. By default they are logged to
+// the store's logger.
+func (s *FileCookieStore) WithErrorHandler(onError ErrorHandler) *FileCookieStore {
+	s.onError = onError
+	return s
+}
+
+func (s *FileCookieStore) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	s.jar().SetCookies(u, cookies)
+}
+
+func (s *FileCookieStore) Cookies(u *url.URL) []*http.Cookie {
+	return s.jar().Cookies(u)
+}
+
+func (s *FileCookieStore) jar() ContextJar {
+	return NewCookieJar(s, errorHandler(s.onError, s.logger))
+}
+
+// SetCookiesContext stores cookies and, without a flush interval, writes
+// them to the file. The file is not written when ctx is already done.
+func (s *FileCookieStore) SetCookiesContext(ctx context.Context, u *url.URL, cookies []*http.Cookie) error {
+	changes, err := newCookieChanges(u, cookies, time.Now(), s.mem.psl)
+	if err != nil || len(changes) == 0 {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	ops := make([]fileOp, len(changes))
+	for i, change := range changes {
+		ops[i] = fileOp{profile: s.profile, change: change}
+	}
+	if err := s.enqueue(ops...); err != nil {
+		return err
+	}
+	logChanges(ctx, s.logger, u.Hostname(), len(cookies), changes)
+	return nil
+}
+
+// CookiesContext returns the cookies for u, first loading the changes
+// other processes wrote when there is no flush interval. Should that fail,
+// the cookies already in memory are returned along with the error.
+func (s *FileCookieStore) CookiesContext(ctx context.Context, u *url.URL) ([]*http.Cookie, error) {
+	err := s.refresh()
+	return s.mem.profile(s.profile).Cookies(u), err
+}
+
+func (s *FileCookieStore) AllCookies() ([]*http.Cookie, error) {
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	return s.mem.profile(s.profile).AllCookies()
+}
+
+func (s *FileCookieStore) Hosts() ([]string, error) {
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	return s.mem.profile(s.profile).Hosts()
+}
+
+func (s *FileCookieStore) DeleteCookie(host string, name string, path string) error {
+	filter, err := deleteCookieFilter(host, name, path)
+	if err != nil {
+		return err
+	}
+	return s.enqueue(fileOp{profile: s.profile, filter: filter})
+}
+
+func (s *FileCookieStore) ClearHost(host string) error {
+	filter, err := clearHostFilter(host)
+	if err != nil {
+		return err
+	}
+	return s.enqueue(fileOp{profile: s.profile, filter: filter})
+}
+
+func (s *FileCookieStore) ClearAll() error {
+	return s.DeleteProfile(s.profile)
+}
+
+// PurgeExpired writes the file without its expired cookies.
+func (s *FileCookieStore) PurgeExpired() error {
+	return s.enqueue(fileOp{profile: s.profile, filter: expiredFilter(time.Now())})
+}
+
+// Profile returns a view of the store acting on the named profile. Flush
+// and Close act on the whole store, whichever view they are called on.
+func (s *FileCookieStore) Profile(name string) Store {
+	return &FileCookieStore{fileStore: s.fileStore, profile: name}
+}
+
+func (s *FileCookieStore) Profiles() ([]string, error) {
+	if err := s.refresh(); err != nil {
+		return nil, err
+	}
+	return s.mem.Profiles()
+}
+
+func (s *FileCookieStore) CloneProfile(src string, dst string) error {
+	if err := s.refresh(); err != nil {
+		return err
+	}
+	entries := s.mem.profile(src).snapshot(time.Now())
+	ops := make([]fileOp, 0, len(entries)+1)
+	ops = append(ops, fileOp{profile: dst, filter: func(entry) bool { return true }})
+	for _, e := range entries {
+		ops = append(ops, fileOp{profile: dst, change: cookieChange{entry: e}})
+	}
+	return s.enqueue(ops...)
+}
+
+func (s *FileCookieStore) DeleteProfile(name string) error {
+	return s.enqueue(fileOp{profile: name, filter: func(entry) bool { return true }})
+}
+
+// enqueue applies ops in memory and queues them for the file, which is
+// written right away when there is no flush interval.
+func (s *fileStore) enqueue(ops ...fileOp) error {
+	s.mu.Lock()
+	s.applyMem(ops)
+	s.pending = append(s.pending, ops...)
+	s.mu.Unlock()
+
+	if s.interval == 0 {
+		return s.Flush()
+	}
+	return nil
+}
+
+func (s *fileStore) applyMem(ops []fileOp) {
+	for _, op := range ops {
+		mem := s.mem.profile(op.profile)
+		if op.filter != nil {
+			mem.remove(op.filter)
+		} else {
+			mem.apply([]cookieChange{op.change})
+		}
+	}
+}
+
+// refresh loads changes written by other processes when there is no flush
+// interval to pick them up.
+func (s *fileStore) refresh() error {
+	if s.interval == 0 && s.modified() {
+		return s.Flush()
+	}
+	return nil
+}
+
+// Flush writes pending changes to the file and loads the changes other
+// processes have written since the last flush.
+func (s *fileStore) Flush() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	merged, err := s.merge(pending)
+	if err != nil {
+		// Keep the changes for the next flush.
+		s.mu.Lock()
+		s.pending = append(pending, s.pending...)
+		s.mu.Unlock()
+		return err
+	}
+
+	// Changes made while the file was locked are applied again on top of
+	// the merged contents; they are written by the next flush.
+	s.mu.Lock()
+	s.mem.resetProfiles(merged)
+	s.applyMem(s.pending)
+	s.mu.Unlock()
+	return nil
+}
+
+// Close stops the periodic flush and writes pending changes.
+func (s *fileStore) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+		s.closeErr = s.Flush()
+	})
+	return s.closeErr
+}
+
+func (s *fileStore) flushLoop() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				s.handleError("Flush", err)
+			}
+		}
+	}
+}
+
+func (s *fileStore) handleError(op string, err error) {
+	errorHandler(s.onError, s.logger)(context.Background(), op, nil, err)
+}
+
+// merge applies pending to the current file contents under the file lock,
+// writes the result when anything changed and returns the merged entries of
+// every profile.
+func (s *fileStore) merge(pending []fileOp) (map[string][]entry, error) {
+	lock, err := os.OpenFile(s.lockPath, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+	if err := lockFile(lock); err != nil {
+		return nil, fmt.Errorf("lock %s: %w", s.lockPath, err)
+	}
+	defer unlockFile(lock)
+
+	stored, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	storedCount := 0
+	profiles := make(map[string]map[string]entry, len(stored))
+	for name, entries := range stored {
+		profiles[name] = make(map[string]entry, len(entries))
+		for _, e := range entries {
+			if !e.expired(now) {
+				profiles[name][e.id()] = e
+			}
+		}
+		storedCount += len(entries)
+	}
+	for _, op := range pending {
+		entries := profiles[op.profile]
+		if entries == nil {
+			entries = make(map[string]entry)
+			profiles[op.profile] = entries
+		}
+		if op.filter == nil {
+			applyChange(entries, op.change)
+			continue
+		}
+		for id, e := range entries {
+			if op.filter(e) {
+				delete(entries, id)
+			}
+		}
+	}
+
+	merged := make(map[string][]entry, len(profiles))
+	mergedCount := 0
+	for name, entries := range profiles {
+		kept := make([]entry, 0, len(entries))
+		for _, e := range entries {
+			if !e.expired(now) {
+				kept = append(kept, e)
+			}
+		}
+		if len(kept) == 0 {
+			continue
+		}
+		sortEntries(kept)
+		merged[name] = kept
+		mergedCount += len(kept)
+	}
+	written := len(pending) > 0 || mergedCount < storedCount
+	if written {
+		if err := s.write(merged); err != nil {
+			return nil, err
+		}
+	}
+	s.logger.Debug("cookie file synchronized", "path", s.path, "cookies", mergedCount, "profiles", len(merged), "changes", len(pending), "written", written)
+	s.seen = s.stamp()
+	return merged, nil
+}
+
+// read returns the entries stored in the file by profile. A missing file is
+// empty, and a corrupt one is renamed so that it can be inspected and is
+// then treated as empty.
+func (s *fileStore) read() (map[string][]entry, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file cookieFile
+	if err := json.Unmarshal(data, &file); err != nil || file.Version < 1 {
+		corruptPath := s.path + ".corrupt-" + strconv.FormatInt(time.Now().Unix(), 10)
+		s.logger.Warn("cookie file is corrupt, moving it aside", "path", s.path, "moved_to", corruptPath)
+		if err := os.Rename(s.path, corruptPath); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+	if file.Version > fileFormatVersion {
+		return nil, fmt.Errorf("cookie file %s has unsupported version %d", s.path, file.Version)
+	}
+	profiles := map[string][]entry{DefaultProfile: file.Cookies}
+	for name, entries := range file.Profiles {
+		if name != DefaultProfile {
+			profiles[name] = entries
+		}
+	}
+	return profiles, nil
+}
+
+func (s *fileStore) write(profiles map[string][]entry) error {
+	file := cookieFile{Version: 1, Cookies: profiles[DefaultProfile]}
+	if file.Cookies == nil {
+		file.Cookies = []entry{}
+	}
+	for name, entries := range profiles {
+		if name == DefaultProfile {
+			continue
+		}
+		if file.Profiles == nil {
+			file.Profiles = make(map[string][]entry)
+			file.Version = fileFormatVersion
+		}
+		file.Profiles[name] = entries
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), "."+filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if err := writeAndSync(tmp, data); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+func writeAndSync(f *os.File, data []byte) error {
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// modified reports whether the file changed since the last flush.
+func (s *fileStore) modified() bool {
+	stamp := s.stamp()
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	return !stamp.equal(s.seen)
+}
+
+func (s *fileStore) stamp() fileStamp {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{info: info}
+}
@@ -0,0 +1,172 @@
+package cookiestore_test
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/takumi3488/twocker/cookiestore"
+)
+
+func newFileCookieStore(t *testing.T, path string, option *cookiestore.FileCookieStoreOption) *cookiestore.FileCookieStore {
+	t.Helper()
+	store, err := cookiestore.NewFileCookieStore(path, option)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, store.Close()) })
+	return store
+}
+
+func TestFileCookieStoreConformance(t *testing.T) {
+	runConformanceTests(t, func(t *testing.T) http.CookieJar {
+		return newFileCookieStore(t, filepath.Join(t.TempDir(), "cookies.json"), nil)
+	})
+}
+
+func TestFileCookieStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	u, _ := url.Parse("https://sub.example.com/some/path")
+	cookiesToSet := []*http.Cookie{
+		{Name: "session-id", Value: "abc123xyz", Path: "/", Domain: "example.com", HttpOnly: true, Expires: time.Now().Add(time.Hour)},
+		{Name: "user_preference", Value: "theme=dark", Path: "/some", Domain: "sub.example.com"},
+	}
+
+	store, err := cookiestore.NewFileCookieStore(path, nil)
+	require.NoError(t, err)
+	store.SetCookies(u, cookiesToSet)
+	require.NoError(t, store.Close())
+
+	reopened := newFileCookieStore(t, path, nil)
+	compareCookieSlices(t, cookiesToSet, reopened.Cookies(u))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+}
+
+func TestFileCookieStoreFlushInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	store := newFileCookieStore(t, path, &cookiestore.FileCookieStoreOption{FlushInterval: time.Hour})
+	u, _ := url.Parse("http://www.example.com/")
+
+	store.SetCookies(u, []*http.Cookie{{Name: "a", Value: "1"}})
+	require.Equal(t, "a=1", cookieHeader(store.Cookies(u)))
+	_, err := os.Stat(path)
+	require.ErrorIs(t, err, os.ErrNotExist, "Cookies should not be written before the flush")
+
+	require.NoError(t, store.Flush())
+	other := newFileCookieStore(t, path, nil)
+	require.Equal(t, "a=1", cookieHeader(other.Cookies(u)))
+}
+
+func TestFileCookieStoreSharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	first := newFileCookieStore(t, path, nil)
+	second := newFileCookieStore(t, path, nil)
+	u, _ := url.Parse("http://www.example.com/")
+
+	first.SetCookies(u, []*http.Cookie{{Name: "a", Value: "1"}})
+	second.SetCookies(u, []*http.Cookie{{Name: "b", Value: "2"}})
+	require.Equal(t, "a=1 b=2", cookieHeader(first.Cookies(u)))
+
+	first.SetCookies(u, []*http.Cookie{{Name: "b", MaxAge: -1}})
+	require.Equal(t, "a=1", cookieHeader(second.Cookies(u)))
+}
+
+func TestFileCookieStoreSameSizeChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	first := newFileCookieStore(t, path, nil)
+	second := newFileCookieStore(t, path, nil)
+	u, _ := url.Parse("http://www.example.com/")
+
+	first.SetCookies(u, []*http.Cookie{{Name: "a", Value: "1"}})
+	require.Equal(t, "a=1", cookieHeader(second.Cookies(u)))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+
+	// A rewrite of the same size within the mtime granularity.
+	first.SetCookies(u, []*http.Cookie{{Name: "a", Value: "2"}})
+	require.NoError(t, os.Chtimes(path, info.ModTime(), info.ModTime()))
+	require.Equal(t, "a=2", cookieHeader(second.Cookies(u)))
+}
+
+func TestFileCookieStoreConcurrentWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	u, _ := url.Parse("http://www.example.com/")
+
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		store := newFileCookieStore(t, path, nil)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				store.SetCookies(u, []*http.Cookie{{Name: "s" + strconv.Itoa(g) + "c" + strconv.Itoa(i), Value: "1"}})
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, newFileCookieStore(t, path, nil).Cookies(u), 40)
+}
+
+func TestFileCookieStoreCorruptionRecovery(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cookies.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"version":1,"cookies":[`), 0o600))
+
+	store := newFileCookieStore(t, path, nil)
+	u, _ := url.Parse("http://www.example.com/")
+	require.Empty(t, store.Cookies(u))
+
+	corrupt, err := filepath.Glob(path + ".corrupt-*")
+	require.NoError(t, err)
+	require.Len(t, corrupt, 1, "The corrupt file should be kept")
+
+	store.SetCookies(u, []*http.Cookie{{Name: "a", Value: "1"}})
+	require.Equal(t, "a=1", cookieHeader(newFileCookieStore(t, path, nil).Cookies(u)))
+}
+
+func TestFileCookieStoreUnsupportedVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"version":99,"cookies":[]}`), 0o600))
+
+	_, err := cookiestore.NewFileCookieStore(path, nil)
+	require.Error(t, err)
+}
+
+func TestFileCookieStoreImportAndExport(t *testing.T) {
+	runImportExportTest(t, newFileCookieStore(t, filepath.Join(t.TempDir(), "cookies.json"), nil))
+}
+
+func TestFileCookieStoreManagement(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	runStoreManagementTest(t, newFileCookieStore(t, path, nil))
+
+	u, _ := url.Parse("http://www.example.com/")
+	store := newFileCookieStore(t, path, nil)
+	store.SetCookies(u, []*http.Cookie{{Name: "a", Value: "1"}, {Name: "b", Value: "2"}})
+	require.NoError(t, store.DeleteCookie("www.example.com", "a", ""))
+	require.Equal(t, "b=2", cookieHeader(newFileCookieStore(t, path, nil).Cookies(u)), "Deletes should be persisted")
+}
+
+func TestFileCookieStoreProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	runProfileTest(t, newFileCookieStore(t, path, nil))
+
+	u, _ := url.Parse("https://www.example.com/")
+	store := newFileCookieStore(t, path, nil)
+	store.SetCookies(u, []*http.Cookie{{Name: "sid", Value: "default"}})
+	store.Profile("work").SetCookies(u, []*http.Cookie{{Name: "sid", Value: "work"}})
+	require.NoError(t, store.Flush())
+
+	reopened := newFileCookieStore(t, path, nil)
+	require.Equal(t, "sid=default", cookieHeader(reopened.Cookies(u)))
+	require.Equal(t, "sid=work", cookieHeader(reopened.Profile("work").Cookies(u)), "Profiles should be persisted")
+}
@@ -0,0 +1,14 @@
+//go:build !unix && !windows
+
+package cookiestore
+
+import "os"
+
+// Platforms without file locks only serialize writers within one process.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
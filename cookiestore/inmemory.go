@@ -1,36 +1,311 @@
 package cookiestore
 
 import (
+	"context"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"sort"
+	"sync"
+	"time"
 )
 
+// InMemoryCookieStore follows the RFC 6265 storage model. Cookies are
+// grouped by the registrable domain of the host that may receive them, and
+// each group has its own lock so that requests to different sites do not
+// contend. It is safe for concurrent use.
 type InMemoryCookieStore struct {
-	cookies map[string]map[string]*http.Cookie
+	mu       sync.RWMutex
+	buckets  map[string]*cookieBucket
+	psl      PublicSuffixList
+	logger   *slog.Logger
+	profiles *inMemoryProfiles
+}
+
+type cookieBucket struct {
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+// inMemoryProfiles is shared by a store and its profiles, each an
+// InMemoryCookieStore of its own.
+type inMemoryProfiles struct {
+	mu     sync.Mutex
+	stores map[string]*InMemoryCookieStore
+}
+
+// WithPublicSuffixList replaces DefaultPublicSuffixList for the store and
+// all of its profiles. A nil list turns the public suffix check off. It must
+// be called before the store is used.
+func (s *InMemoryCookieStore) WithPublicSuffixList(psl PublicSuffixList) *InMemoryCookieStore {
+	s.profiles.each(func(store *InMemoryCookieStore) { store.psl = psl })
+	return s
+}
+
+// WithLogger sets the logger for events of the store and all of its
+// profiles, which are logged at debug level with hosts and cookie counts but
+// never cookie values. It defaults to slog.Default.
+func (s *InMemoryCookieStore) WithLogger(logger *slog.Logger) *InMemoryCookieStore {
+	s.profiles.each(func(store *InMemoryCookieStore) { store.logger = logger })
+	return s
 }
 
 func (s *InMemoryCookieStore) SetCookies(url *url.URL, cookies []*http.Cookie) {
-	if s.cookies[url.Hostname()] == nil {
-		s.cookies[url.Hostname()] = make(map[string]*http.Cookie)
+	_ = s.SetCookiesContext(context.Background(), url, cookies)
+}
+
+// SetCookiesContext is SetCookies with an error for URLs without a host.
+func (s *InMemoryCookieStore) SetCookiesContext(ctx context.Context, u *url.URL, cookies []*http.Cookie) error {
+	changes, err := newCookieChanges(u, cookies, time.Now(), s.psl)
+	if err != nil {
+		return err
+	}
+	s.apply(changes)
+	logChanges(ctx, loggerOrDefault(s.logger), u.Hostname(), len(cookies), changes)
+	return nil
+}
+
+func (s *InMemoryCookieStore) CookiesContext(ctx context.Context, u *url.URL) ([]*http.Cookie, error) {
+	if _, err := canonicalHost(u.Hostname()); err != nil {
+		return nil, err
+	}
+	return s.Cookies(u), nil
+}
+
+// AllCookies returns every unexpired cookie. Domain cookies have a leading
+// dot in Domain and host-only cookies the bare host, the form read by
+// ImportCookies.
+func (s *InMemoryCookieStore) AllCookies() ([]*http.Cookie, error) {
+	entries := s.snapshot(time.Now())
+	cookies := make([]*http.Cookie, len(entries))
+	for i, e := range entries {
+		cookies[i] = exportCookie(e)
+	}
+	sortCookies(cookies)
+	return cookies, nil
+}
+
+func (s *InMemoryCookieStore) Hosts() ([]string, error) {
+	return entryHosts(s.snapshot(time.Now())), nil
+}
+
+func (s *InMemoryCookieStore) DeleteCookie(host string, name string, path string) error {
+	filter, err := deleteCookieFilter(host, name, path)
+	if err != nil {
+		return err
+	}
+	s.remove(filter)
+	return nil
+}
+
+func (s *InMemoryCookieStore) ClearHost(host string) error {
+	filter, err := clearHostFilter(host)
+	if err != nil {
+		return err
+	}
+	s.remove(filter)
+	return nil
+}
+
+func (s *InMemoryCookieStore) ClearAll() error {
+	s.reset(nil)
+	return nil
+}
+
+func (s *InMemoryCookieStore) PurgeExpired() error {
+	s.remove(expiredFilter(time.Now()))
+	return nil
+}
+
+// Profile returns the store of the named profile, which shares the public
+// suffix list and logger of the store.
+func (s *InMemoryCookieStore) Profile(name string) Store {
+	return s.profile(name)
+}
+
+func (s *InMemoryCookieStore) Profiles() ([]string, error) {
+	s.profiles.mu.Lock()
+	defer s.profiles.mu.Unlock()
+	now := time.Now()
+	names := []string{}
+	for name, store := range s.profiles.stores {
+		if name != DefaultProfile && len(store.snapshot(now)) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *InMemoryCookieStore) CloneProfile(src string, dst string) error {
+	s.profile(dst).reset(s.profile(src).snapshot(time.Now()))
+	return nil
+}
+
+func (s *InMemoryCookieStore) DeleteProfile(name string) error {
+	return s.profile(name).ClearAll()
+}
+
+func (s *InMemoryCookieStore) profile(name string) *InMemoryCookieStore {
+	s.profiles.mu.Lock()
+	defer s.profiles.mu.Unlock()
+	store := s.profiles.stores[name]
+	if store == nil {
+		root := s.profiles.stores[DefaultProfile]
+		store = &InMemoryCookieStore{
+			buckets:  make(map[string]*cookieBucket),
+			psl:      root.psl,
+			logger:   root.logger,
+			profiles: s.profiles,
+		}
+		s.profiles.stores[name] = store
 	}
-	for _, cookie := range cookies {
-		s.cookies[url.Hostname()][cookie.Name] = cookie
+	return store
+}
+
+// apply stores or removes the entries of changes. A replaced entry keeps its
+// creation time.
+func (s *InMemoryCookieStore) apply(changes []cookieChange) {
+	for _, change := range changes {
+		bucket := s.bucket(jarKey(change.entry.Domain, s.psl), !change.remove)
+		if bucket == nil {
+			continue
+		}
+		bucket.mu.Lock()
+		applyChange(bucket.entries, change)
+		bucket.mu.Unlock()
 	}
 }
 
+func applyChange(entries map[string]entry, change cookieChange) {
+	id := change.entry.id()
+	if change.remove {
+		delete(entries, id)
+		return
+	}
+	e := change.entry
+	if old, ok := entries[id]; ok {
+		e.Creation = old.Creation
+	}
+	entries[id] = e
+}
+
 func (s *InMemoryCookieStore) Cookies(url *url.URL) []*http.Cookie {
-	cookies := make([]*http.Cookie, 0)
-	if s.cookies[url.Hostname()] == nil {
-		return cookies
+	host, err := canonicalHost(url.Hostname())
+	if err != nil {
+		return nil
+	}
+	bucket := s.bucket(jarKey(host, s.psl), false)
+	if bucket == nil {
+		return nil
+	}
+	now := time.Now()
+	bucket.mu.Lock()
+	entries := make([]entry, 0, len(bucket.entries))
+	for id, e := range bucket.entries {
+		if e.expired(now) {
+			delete(bucket.entries, id)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	bucket.mu.Unlock()
+	return selectCookies(entries, url, now)
+}
+
+// remove deletes every entry selected by filter.
+func (s *InMemoryCookieStore) remove(filter entryFilter) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, bucket := range s.buckets {
+		bucket.mu.Lock()
+		for id, e := range bucket.entries {
+			if filter(e) {
+				delete(bucket.entries, id)
+			}
+		}
+		bucket.mu.Unlock()
 	}
-	for _, cookie := range s.cookies[url.Hostname()] {
-		cookies = append(cookies, cookie)
+}
+
+// snapshot returns every unexpired entry.
+func (s *InMemoryCookieStore) snapshot(now time.Time) []entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var entries []entry
+	for _, bucket := range s.buckets {
+		bucket.mu.Lock()
+		for _, e := range bucket.entries {
+			if !e.expired(now) {
+				entries = append(entries, e)
+			}
+		}
+		bucket.mu.Unlock()
+	}
+	return entries
+}
+
+// reset replaces every entry with entries.
+func (s *InMemoryCookieStore) reset(entries []entry) {
+	buckets := make(map[string]*cookieBucket)
+	for _, e := range entries {
+		key := jarKey(e.Domain, s.psl)
+		bucket := buckets[key]
+		if bucket == nil {
+			bucket = &cookieBucket{entries: make(map[string]entry)}
+			buckets[key] = bucket
+		}
+		bucket.entries[e.id()] = e
+	}
+	s.mu.Lock()
+	s.buckets = buckets
+	s.mu.Unlock()
+}
+
+// resetProfiles replaces the entries of every profile with those in
+// profiles.
+func (s *InMemoryCookieStore) resetProfiles(profiles map[string][]entry) {
+	for name := range profiles {
+		s.profile(name)
+	}
+	s.profiles.mu.Lock()
+	defer s.profiles.mu.Unlock()
+	for name, store := range s.profiles.stores {
+		store.reset(profiles[name])
+	}
+}
+
+func (p *inMemoryProfiles) each(fn func(store *InMemoryCookieStore)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, store := range p.stores {
+		fn(store)
+	}
+}
+
+// bucket returns the bucket for key, creating it when create is set.
+func (s *InMemoryCookieStore) bucket(key string, create bool) *cookieBucket {
+	s.mu.RLock()
+	bucket := s.buckets[key]
+	s.mu.RUnlock()
+	if bucket != nil || !create {
+		return bucket
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if bucket = s.buckets[key]; bucket == nil {
+		bucket = &cookieBucket{entries: make(map[string]entry)}
+		s.buckets[key] = bucket
 	}
-	return cookies
+	return bucket
 }
 
 func NewInMemoryCookieStore() *InMemoryCookieStore {
-	return &InMemoryCookieStore{
-		cookies: make(map[string]map[string]*http.Cookie),
+	s := &InMemoryCookieStore{
+		buckets: make(map[string]*cookieBucket),
+		psl:     DefaultPublicSuffixList,
 	}
+	s.profiles = &inMemoryProfiles{stores: map[string]*InMemoryCookieStore{DefaultProfile: s}}
+	return s
 }
@@ -0,0 +1,122 @@
+package cookiestore_test
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/takumi3488/twocker/cookiestore"
+)
+
+func TestInMemoryCookieStoreConformance(t *testing.T) {
+	runConformanceTests(t, func(t *testing.T) http.CookieJar {
+		return cookiestore.NewInMemoryCookieStore()
+	})
+}
+
+func TestInMemoryCookieStoreAttributes(t *testing.T) {
+	store := cookiestore.NewInMemoryCookieStore()
+	testURL, _ := url.Parse("https://sub.example.com/some/path?query=1")
+	cookiesToSet := []*http.Cookie{
+		{Name: "session-id", Value: "abc123xyz", Path: "/", Domain: "example.com", HttpOnly: true},
+		{Name: "user_preference", Value: "theme=dark&lang=en", Path: "/some", Domain: "sub.example.com"},
+		{Name: "host-only", Value: "1", Path: "/", Secure: true, SameSite: http.SameSiteStrictMode},
+	}
+	store.SetCookies(testURL, cookiesToSet)
+
+	retrieved := store.Cookies(testURL)
+	compareCookieSlices(t, cookiesToSet, retrieved)
+	for _, c := range retrieved {
+		if c.Name == "host-only" {
+			require.True(t, c.Secure, "Secure flag should be kept")
+			require.Equal(t, http.SameSiteStrictMode, c.SameSite, "SameSite should be kept")
+		}
+	}
+
+	baseDomainURL, _ := url.Parse("https://example.com/")
+	compareCookieSlices(t, cookiesToSet[:1], store.Cookies(baseDomainURL))
+}
+
+func TestInMemoryCookieStoreConcurrentAccess(t *testing.T) {
+	store := cookiestore.NewInMemoryCookieStore()
+	hosts := []string{"a.example.com", "b.example.com", "example.org", "www.example.net"}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				u, _ := url.Parse("https://" + hosts[(g+i)%len(hosts)] + "/path")
+				name := "c" + strconv.Itoa(i%10)
+				store.SetCookies(u, []*http.Cookie{
+					{Name: name, Value: strconv.Itoa(g)},
+					{Name: "shared", Value: strconv.Itoa(i), Domain: u.Hostname()},
+				})
+				if i%7 == 0 {
+					store.SetCookies(u, []*http.Cookie{{Name: name, MaxAge: -1}})
+				}
+				store.Cookies(u)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, host := range hosts {
+		u, _ := url.Parse("https://" + host + "/path")
+		cookies := store.Cookies(u)
+		require.NotEmpty(t, cookies, "Cookies for %s", host)
+		names := make(map[string]bool)
+		for _, c := range cookies {
+			require.False(t, names[c.Name], "Duplicate cookie %s for %s", c.Name, host)
+			names[c.Name] = true
+		}
+	}
+}
+
+func TestInMemoryCookieStoreConcurrentSameHost(t *testing.T) {
+	store := cookiestore.NewInMemoryCookieStore()
+	u, _ := url.Parse("http://www.example.com/")
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				store.SetCookies(u, []*http.Cookie{{Name: "n" + strconv.Itoa(g), Value: strconv.Itoa(i)}})
+				store.Cookies(u)
+			}
+		}()
+	}
+	wg.Wait()
+
+	cookies := store.Cookies(u)
+	require.Len(t, cookies, 16)
+	for _, c := range cookies {
+		require.Equal(t, "499", c.Value, "Last value of %s", c.Name)
+	}
+}
+
+func TestInMemoryCookieStoreManagement(t *testing.T) {
+	runStoreManagementTest(t, cookiestore.NewInMemoryCookieStore())
+}
+
+func TestInMemoryCookieStoreProfiles(t *testing.T) {
+	runProfileTest(t, cookiestore.NewInMemoryCookieStore())
+}
+
+func TestInMemoryCookieStoreProfileSettings(t *testing.T) {
+	store := cookiestore.NewInMemoryCookieStore()
+	profile := store.Profile("alice")
+	// Settings made after a profile was created reach it too.
+	store.WithPublicSuffixList(nil)
+
+	u, _ := url.Parse("https://www.example.co.uk/")
+	profile.SetCookies(u, []*http.Cookie{{Name: "a", Value: "1", Domain: "co.uk"}})
+	require.Equal(t, "a=1", cookieHeader(profile.Cookies(u)), "The public suffix check should be off for the profile")
+}
@@ -0,0 +1,61 @@
+package cookiestore
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+)
+
+// LogErrors is the default ErrorHandler of NewCookieJar. It logs to
+// slog.Default like LogErrorsTo.
+func LogErrors(ctx context.Context, op string, u *url.URL, err error) {
+	LogErrorsTo(slog.Default())(ctx, op, u, err)
+}
+
+// LogErrorsTo returns an ErrorHandler that logs errors to logger at error
+// level with the operation and host. It is the default handler of the
+// stores, using the logger set with WithLogger.
+func LogErrorsTo(logger *slog.Logger) ErrorHandler {
+	return func(ctx context.Context, op string, u *url.URL, err error) {
+		args := []any{"op", op, "error", err}
+		if u != nil {
+			args = append(args, "host", u.Hostname())
+		}
+		logger.ErrorContext(ctx, "cookie store operation failed", args...)
+	}
+}
+
+func loggerOrDefault(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
+
+// errorHandler returns onError, or a handler logging to logger.
+func errorHandler(onError ErrorHandler, logger *slog.Logger) ErrorHandler {
+	if onError == nil {
+		return LogErrorsTo(loggerOrDefault(logger))
+	}
+	return onError
+}
+
+// logChanges logs the outcome of storing received Set-Cookie values from
+// host at debug level.
+func logChanges(ctx context.Context, logger *slog.Logger, host string, received int, changes []cookieChange) {
+	if !logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	removed := 0
+	for _, change := range changes {
+		if change.remove {
+			removed++
+		}
+	}
+	logger.DebugContext(ctx, "cookies stored",
+		"host", host,
+		"stored", len(changes)-removed,
+		"removed", removed,
+		"rejected", received-len(changes),
+	)
+}
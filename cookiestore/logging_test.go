@@ -0,0 +1,55 @@
+package cookiestore_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/takumi3488/twocker/cookiestore"
+)
+
+func TestInMemoryCookieStoreWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	store := cookiestore.NewInMemoryCookieStore().WithLogger(logger)
+
+	u, _ := url.Parse("https://www.example.com/")
+	store.SetCookies(u, []*http.Cookie{
+		{Name: "session", Value: "cookie-secret"},
+		{Name: "old", MaxAge: -1},
+		{Name: "bad", Value: "1", Domain: "other.com"},
+	})
+	require.Contains(t, buf.String(), `msg="cookies stored" host=www.example.com stored=1 removed=1 rejected=1`)
+	require.NotContains(t, buf.String(), "cookie-secret")
+}
+
+func TestRedisCookieStoreLogsErrors(t *testing.T) {
+	var buf bytes.Buffer
+	store := cookiestore.NewRedisCookieStore(&cookiestore.NewRedisCookieStoreOption{
+		Addr:        "127.0.0.1:1",
+		DialTimeout: 100 * time.Millisecond,
+		MaxRetries:  -1,
+	}, nil).WithLogger(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	u, _ := url.Parse("https://example.com/?token=query-secret")
+	store.SetCookies(u, []*http.Cookie{{Name: "a", Value: "cookie-secret"}})
+	require.Contains(t, buf.String(), `level=ERROR msg="cookie store operation failed" op=SetCookies`)
+	require.Contains(t, buf.String(), "host=example.com")
+	require.NotContains(t, buf.String(), "secret")
+}
+
+func TestFileCookieStoreLogsCorruption(t *testing.T) {
+	var buf bytes.Buffer
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0o600))
+
+	newFileCookieStore(t, path, &cookiestore.FileCookieStoreOption{Logger: slog.New(slog.NewTextHandler(&buf, nil))})
+	require.Contains(t, buf.String(), `level=WARN msg="cookie file is corrupt, moving it aside"`)
+}
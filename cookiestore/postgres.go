@@ -3,165 +3,622 @@ package cookiestore
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"regexp"
+	"sort"
 	"strings"
-	"sync"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type PostgresCookieStore struct {
 	db        *sql.DB
 	tableName string
-	mu        sync.RWMutex
+	indexName string
+	timeout   time.Duration
+	stmts     sqlStatements
+	// ownsStatements is unset for profiles, which share the statements of
+	// the store they came from.
+	ownsStatements bool
+	profile        string
+	psl            PublicSuffixList
+	onError        ErrorHandler
+	logger         *slog.Logger
 }
 
+type PostgresCookieStoreOption struct {
+	// Schema is the schema of the table. When empty, the table name may be
+	// qualified itself, as in "crawler.cookies", or is looked up through the
+	// search_path.
+	Schema string
+	// DisableAutoMigrate leaves the table alone: it is neither created nor
+	// converted from the earlier layout, and must already exist.
+	DisableAutoMigrate bool
+	// StatementTimeout bounds the queries of each call, including setting up
+	// the table. It defaults to 5 seconds.
+	StatementTimeout time.Duration
+}
+
+// sqlStatements are prepared once per store and reused by every call. The
+// SQL stores take the same arguments for each.
+type sqlStatements struct {
+	selectDomains *sql.Stmt
+	selectAll     *sql.Stmt
+	upsert        *sql.Stmt
+	deleteOne     *sql.Stmt
+	deleteCookie  *sql.Stmt
+	clearHost     *sql.Stmt
+	clearAll      *sql.Stmt
+	purgeExpired  *sql.Stmt
+	profiles      *sql.Stmt
+	clone         *sql.Stmt
+}
+
+// The table holds one row per cookie and profile. Its primary key indexes
+// lookups by profile and domain; expires is NULL for session cookies.
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS %[1]s (
+	profile TEXT NOT NULL DEFAULT '',
+	domain TEXT NOT NULL,
+	path TEXT NOT NULL,
+	name TEXT NOT NULL,
+	value TEXT NOT NULL,
+	quoted BOOLEAN NOT NULL DEFAULT FALSE,
+	expires TIMESTAMPTZ,
+	secure BOOLEAN NOT NULL DEFAULT FALSE,
+	httponly BOOLEAN NOT NULL DEFAULT FALSE,
+	samesite TEXT NOT NULL DEFAULT '',
+	host_only BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (profile, domain, path, name)
+);
+CREATE INDEX IF NOT EXISTS %[2]s ON %[1]s (expires) WHERE expires IS NOT NULL;`
+
+const sqlColumns = "domain, path, name, value, quoted, expires, secure, httponly, samesite, host_only, created_at"
+
+const defaultStatementTimeout = 5 * time.Second
+
+// postgresIdentifier matches the identifiers accepted for schemas and
+// tables: those that need no quoting, apart from case.
+var postgresIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]{0,62}$`)
+
 func NewPostgresCookieStore(db *sql.DB, tableName string) (*PostgresCookieStore, error) {
+	return NewPostgresCookieStoreWithOption(db, tableName, nil)
+}
+
+func NewPostgresCookieStoreWithOption(db *sql.DB, tableName string, option *PostgresCookieStoreOption) (*PostgresCookieStore, error) {
 	if db == nil {
 		return nil, fmt.Errorf("database connection is nil")
 	}
 	if tableName == "" {
 		return nil, fmt.Errorf("table name cannot be empty")
 	}
+	if option == nil {
+		option = &PostgresCookieStoreOption{}
+	}
+	qualified, indexName, err := quoteTableName(option.Schema, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &PostgresCookieStore{
+		db:             db,
+		tableName:      qualified,
+		indexName:      indexName,
+		timeout:        option.StatementTimeout,
+		ownsStatements: true,
+		psl:            DefaultPublicSuffixList,
+	}
+	if s.timeout <= 0 {
+		s.timeout = defaultStatementTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
 
 	// Test the connection first
-	err := db.Ping()
-	if err != nil {
+	if err := db.PingContext(ctx); err != nil {
 		return nil, fmt.Errorf("database connection test failed: %w", err)
 	}
+	if !option.DisableAutoMigrate {
+		if err := s.migrate(ctx); err != nil {
+			return nil, fmt.Errorf("failed to create table %s: %w", s.tableName, err)
+		}
+	}
+	if err := s.prepare(ctx); err != nil {
+		return nil, fmt.Errorf("failed to prepare statements for table %s: %w", s.tableName, err)
+	}
+	return s, nil
+}
+
+// quoteTableName validates tableName, qualified by schema or by itself, and
+// returns it quoted along with the quoted name of its expiry index. Names
+// are folded to lower case, as Postgres does with unquoted identifiers, so
+// existing tables keep being found.
+func quoteTableName(schema string, tableName string) (string, string, error) {
+	parts := strings.Split(tableName, ".")
+	if schema != "" {
+		parts = append([]string{schema}, parts...)
+	}
+	if len(parts) > 2 {
+		return "", "", fmt.Errorf("invalid table name %q: too many qualifiers", tableName)
+	}
+	quoted := make([]string, len(parts))
+	for i, part := range parts {
+		if !postgresIdentifier.MatchString(part) {
+			return "", "", fmt.Errorf("invalid identifier %q in table name %q", part, tableName)
+		}
+		parts[i] = strings.ToLower(part)
+		quoted[i] = pq.QuoteIdentifier(parts[i])
+	}
+	return strings.Join(quoted, "."), pq.QuoteIdentifier(parts[len(parts)-1] + "_expires_idx"), nil
+}
+
+// WithPublicSuffixList replaces DefaultPublicSuffixList. A nil list turns
+// the public suffix check off.
+func (s *PostgresCookieStore) WithPublicSuffixList(psl PublicSuffixList) *PostgresCookieStore {
+	s.psl = psl
+	return s
+}
+
+// WithErrorHandler sets the handler for errors of SetCookies and Cookies,
+// which cannot return them. By default they are logged to the store's
+// logger.
+func (s *PostgresCookieStore) WithErrorHandler(onError ErrorHandler) *PostgresCookieStore {
+	s.onError = onError
+	return s
+}
+
+// WithLogger sets the logger for store events and, unless WithErrorHandler
+// was used, errors. Events are logged at debug level with hosts and cookie
+// counts but never cookie values. It defaults to slog.Default.
+func (s *PostgresCookieStore) WithLogger(logger *slog.Logger) *PostgresCookieStore {
+	s.logger = logger
+	return s
+}
+
+func (s *PostgresCookieStore) WithContext(ctx context.Context) http.CookieJar {
+	return s.jar().WithContext(ctx)
+}
+
+func (s *PostgresCookieStore) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	s.jar().SetCookies(u, cookies)
+}
+
+func (s *PostgresCookieStore) Cookies(u *url.URL) []*http.Cookie {
+	return s.jar().Cookies(u)
+}
 
-	createTableSQL := fmt.Sprintf(`
-	CREATE TABLE IF NOT EXISTS %s (
-		host TEXT PRIMARY KEY,
-		cookies TEXT NOT NULL
-	);`, tableName)
+func (s *PostgresCookieStore) jar() ContextJar {
+	return NewCookieJar(s, errorHandler(s.onError, s.logger))
+}
+
+func (s *PostgresCookieStore) log() *slog.Logger {
+	return loggerOrDefault(s.logger)
+}
 
-	// Set a timeout context for the query execution
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// SetCookiesContext stores cookies in one transaction. Each cookie replaces
+// the row with its name, domain and path, keeping its creation time, or
+// deletes it when it has expired. Rows are written in a fixed order under
+// row locks, so processes sharing the table neither lose updates nor
+// deadlock.
+func (s *PostgresCookieStore) SetCookiesContext(ctx context.Context, u *url.URL, cookies []*http.Cookie) error {
+	changes, err := newCookieChanges(u, cookies, time.Now(), s.psl)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if len(changes) == 0 {
+		s.log().DebugContext(ctx, "cookies rejected", "host", host, "rejected", len(cookies))
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
 
-	_, err = db.ExecContext(ctx, createTableSQL)
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create table %s: %w", tableName, err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	return &PostgresCookieStore{
-		db:        db,
-		tableName: tableName,
-	}, nil
+	ordered := make([]cookieChange, len(changes))
+	copy(ordered, changes)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].entry.id() < ordered[j].entry.id()
+	})
+	upsert := tx.StmtContext(ctx, s.stmts.upsert)
+	deleteOne := tx.StmtContext(ctx, s.stmts.deleteOne)
+	for _, change := range ordered {
+		e := change.entry
+		if change.remove {
+			_, err = deleteOne.ExecContext(ctx, s.profile, e.Domain, e.Path, e.Name)
+		} else {
+			err = upsertEntry(ctx, upsert, s.profile, e)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save cookies for host %s: %w", host, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to save cookies for host %s: %w", host, err)
+	}
+	logChanges(ctx, s.log(), host, len(cookies), changes)
+	return nil
 }
 
-func (s *PostgresCookieStore) SetCookies(u *url.URL, cookies []*http.Cookie) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *PostgresCookieStore) CookiesContext(ctx context.Context, u *url.URL) ([]*http.Cookie, error) {
+	host, err := canonicalHost(u.Hostname())
+	if err != nil {
+		return nil, err
+	}
 
-	host := u.Hostname()
-	if host == "" {
-		log.Printf("Warning: SetCookies called with URL without hostname: %s", u.String())
-		return
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	now := time.Now()
+	entries, err := s.query(ctx, s.stmts.selectDomains, s.profile, pq.Array(cookieDomains(host, s.psl)), now)
+	if err != nil {
+		return nil, err
 	}
+	cookies := selectCookies(entries, u, now)
+	s.log().DebugContext(ctx, "cookies loaded", "host", host, "count", len(cookies))
+	return cookies, nil
+}
 
-	// We'll store all cookies with the URL's hostname
-	// This simplifies our implementation and ensures all cookies set for a URL are retrievable
+func (s *PostgresCookieStore) AllCookies() ([]*http.Cookie, error) {
+	entries, err := s.entries(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	cookies := make([]*http.Cookie, len(entries))
+	for i, e := range entries {
+		cookies[i] = exportCookie(e)
+	}
+	sortCookies(cookies)
+	return cookies, nil
+}
 
-	cookiesJSON, err := json.Marshal(cookies)
+func (s *PostgresCookieStore) Hosts() ([]string, error) {
+	entries, err := s.entries(context.Background())
 	if err != nil {
-		log.Printf("Error marshaling cookies for host %s: %v", host, err)
-		return
+		return nil, err
 	}
+	return entryHosts(entries), nil
+}
 
-	upsertSQL := fmt.Sprintf(`
-	INSERT INTO %s (host, cookies) VALUES ($1, $2)
-	ON CONFLICT (host) DO UPDATE SET cookies = $2;`, s.tableName)
+func (s *PostgresCookieStore) DeleteCookie(host string, name string, path string) error {
+	host, err := canonicalHost(host)
+	if err != nil {
+		return err
+	}
+	return s.exec(context.Background(), s.stmts.deleteCookie, s.profile, host, name, path)
+}
+
+func (s *PostgresCookieStore) ClearHost(host string) error {
+	host, err := canonicalHost(host)
+	if err != nil {
+		return err
+	}
+	return s.exec(context.Background(), s.stmts.clearHost, s.profile, host)
+}
+
+func (s *PostgresCookieStore) ClearAll() error {
+	return s.exec(context.Background(), s.stmts.clearAll, s.profile)
+}
+
+func (s *PostgresCookieStore) PurgeExpired() error {
+	return s.exec(context.Background(), s.stmts.purgeExpired, s.profile, time.Now())
+}
+
+// Profile returns a view of the store acting on the named profile, which
+// shares the store's table and statements.
+func (s *PostgresCookieStore) Profile(name string) Store {
+	return s.withProfile(name)
+}
+
+func (s *PostgresCookieStore) Profiles() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	rows, err := s.stmts.profiles.QueryContext(ctx, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list profiles: %w", err)
+	}
+	defer rows.Close()
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan profile row: %w", err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate profile rows: %w", err)
+	}
+	return names, nil
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// CloneProfile replaces the cookies of dst with those of src in one
+// transaction.
+func (s *PostgresCookieStore) CloneProfile(src string, dst string) error {
+	if src == dst {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
 	defer cancel()
 
-	_, err = s.db.ExecContext(ctx, upsertSQL, host, string(cookiesJSON))
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Printf("Error saving cookies for host %s to database: %v", host, err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.StmtContext(ctx, s.stmts.clearAll).ExecContext(ctx, dst); err != nil {
+		return fmt.Errorf("failed to clone profile %q: %w", src, err)
 	}
+	if _, err := tx.StmtContext(ctx, s.stmts.clone).ExecContext(ctx, src, dst, time.Now()); err != nil {
+		return fmt.Errorf("failed to clone profile %q: %w", src, err)
+	}
+	return tx.Commit()
 }
 
-func (s *PostgresCookieStore) Cookies(u *url.URL) []*http.Cookie {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+func (s *PostgresCookieStore) DeleteProfile(name string) error {
+	return s.withProfile(name).ClearAll()
+}
 
-	host := u.Hostname()
-	if host == "" {
-		log.Printf("Warning: Cookies called with URL without hostname: %s", u.String())
+func (s *PostgresCookieStore) withProfile(name string) *PostgresCookieStore {
+	view := *s
+	view.profile = name
+	view.ownsStatements = false
+	return &view
+}
+
+// Close releases the store's prepared statements. The database is left
+// open. Closing a profile does nothing.
+func (s *PostgresCookieStore) Close() error {
+	if !s.ownsStatements {
 		return nil
 	}
+	return s.stmts.close()
+}
 
-	// Get all cookies from the database
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (s *PostgresCookieStore) exec(ctx context.Context, stmt *sql.Stmt, args ...any) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
 	defer cancel()
 
-	selectSQL := fmt.Sprintf("SELECT host, cookies FROM %s;", s.tableName)
-	rows, err := s.db.QueryContext(ctx, selectSQL)
+	if _, err := stmt.ExecContext(ctx, args...); err != nil {
+		return fmt.Errorf("failed to delete cookies: %w", err)
+	}
+	return nil
+}
+
+// entries returns every unexpired cookie in the table.
+func (s *PostgresCookieStore) entries(ctx context.Context) ([]entry, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	return s.query(ctx, s.stmts.selectAll, s.profile, time.Now())
+}
+
+func (s *PostgresCookieStore) query(ctx context.Context, stmt *sql.Stmt, args ...any) ([]entry, error) {
+	rows, err := stmt.QueryContext(ctx, args...)
 	if err != nil {
-		log.Printf("Error retrieving cookies from database: %v", err)
-		return nil
+		return nil, fmt.Errorf("failed to retrieve cookies: %w", err)
 	}
 	defer rows.Close()
 
-	// We'll collect all applicable cookies here
-	var allCookies []*http.Cookie
-
-	// Process each cookie entry
+	var entries []entry
 	for rows.Next() {
-		var dbHost string
-		var cookiesJSON string
+		var e entry
+		var expires sql.NullTime
+		err := rows.Scan(&e.Domain, &e.Path, &e.Name, &e.Value, &e.Quoted, &expires,
+			&e.Secure, &e.HttpOnly, &e.SameSite, &e.HostOnly, &e.Creation)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan cookie row: %w", err)
+		}
+		e.Persistent = expires.Valid
+		e.Expires = expires.Time
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate cookie rows: %w", err)
+	}
+	return entries, nil
+}
 
-		if err := rows.Scan(&dbHost, &cookiesJSON); err != nil {
-			log.Printf("Error scanning cookie row: %v", err)
+func (st *sqlStatements) all() []**sql.Stmt {
+	return []**sql.Stmt{
+		&st.selectDomains, &st.selectAll, &st.upsert, &st.deleteOne,
+		&st.deleteCookie, &st.clearHost, &st.clearAll, &st.purgeExpired,
+		&st.profiles, &st.clone,
+	}
+}
+
+func (st *sqlStatements) close() error {
+	var firstErr error
+	for _, stmt := range st.all() {
+		if *stmt == nil {
 			continue
 		}
+		if err := (*stmt).Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
 
-		var cookies []*http.Cookie
-		if err := json.Unmarshal([]byte(cookiesJSON), &cookies); err != nil {
-			log.Printf("Error unmarshaling cookies for host %s: %v", dbHost, err)
-			continue
+func (s *PostgresCookieStore) prepare(ctx context.Context) error {
+	queries := []string{
+		fmt.Sprintf("SELECT %s FROM %s WHERE profile = $1 AND domain = ANY($2) AND (expires IS NULL OR expires > $3);", sqlColumns, s.tableName),
+		fmt.Sprintf("SELECT %s FROM %s WHERE profile = $1 AND (expires IS NULL OR expires > $2);", sqlColumns, s.tableName),
+		upsertSQL(s.tableName),
+		fmt.Sprintf("DELETE FROM %s WHERE profile = $1 AND domain = $2 AND path = $3 AND name = $4;", s.tableName),
+		fmt.Sprintf("DELETE FROM %s WHERE profile = $1 AND domain = $2 AND name = $3 AND ($4 = '' OR path = $4);", s.tableName),
+		fmt.Sprintf("DELETE FROM %s WHERE profile = $1 AND (domain = $2 OR right(domain, length($2) + 1) = '.' || $2);", s.tableName),
+		fmt.Sprintf("DELETE FROM %s WHERE profile = $1;", s.tableName),
+		fmt.Sprintf("DELETE FROM %s WHERE profile = $1 AND expires <= $2;", s.tableName),
+		fmt.Sprintf("SELECT DISTINCT profile FROM %s WHERE profile <> '' AND (expires IS NULL OR expires > $1) ORDER BY profile;", s.tableName),
+		fmt.Sprintf("INSERT INTO %[1]s (profile, %[2]s) SELECT $2, %[2]s FROM %[1]s WHERE profile = $1 AND (expires IS NULL OR expires > $3);", s.tableName, sqlColumns),
+	}
+	for i, stmt := range s.stmts.all() {
+		var err error
+		if *stmt, err = s.db.PrepareContext(ctx, queries[i]); err != nil {
+			s.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+func upsertSQL(tableName string) string {
+	return fmt.Sprintf(`
+	INSERT INTO %s (profile, %s) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	ON CONFLICT (profile, domain, path, name) DO UPDATE SET
+		value = EXCLUDED.value, quoted = EXCLUDED.quoted, expires = EXCLUDED.expires,
+		secure = EXCLUDED.secure, httponly = EXCLUDED.httponly, samesite = EXCLUDED.samesite,
+		host_only = EXCLUDED.host_only;`, tableName, sqlColumns)
+}
+
+func upsertEntry(ctx context.Context, upsert *sql.Stmt, profile string, e entry) error {
+	var expires sql.NullTime
+	if e.Persistent {
+		expires = sql.NullTime{Time: e.Expires, Valid: true}
+	}
+	_, err := upsert.ExecContext(ctx, profile, e.Domain, e.Path, e.Name, e.Value, e.Quoted, expires,
+		e.Secure, e.HttpOnly, e.SameSite, e.HostOnly, e.Creation)
+	return err
+}
+
+// migrate creates the table, first converting a table of an earlier layout
+// in place: one row of JSON-encoded cookies per host, or one row per cookie
+// without profiles. An advisory lock keeps processes starting together from
+// converting it twice.
+func (s *PostgresCookieStore) migrate(ctx context.Context) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1));", s.tableName); err != nil {
+		return err
+	}
+	columns, err := tableColumns(ctx, tx, s.tableName)
+	if err != nil {
+		return err
+	}
+	legacy := columns["cookies"]
+	if len(columns) > 0 && !legacy && !columns["profile"] {
+		if err := addProfileColumn(ctx, tx, s.tableName); err != nil {
+			return err
 		}
+		s.log().InfoContext(ctx, "added profile column to cookie table", "table", s.tableName)
+	}
 
-		// For each cookie, check if it applies to the requested URL
-		for _, cookie := range cookies {
-			cookieDomain := cookie.Domain
-			if cookieDomain == "" {
-				// Host-only cookie
-				if dbHost == host {
-					allCookies = append(allCookies, cookie)
-				}
-				continue
+	var stored []entry
+	if legacy {
+		if stored, err = readLegacyRows(ctx, tx, s.tableName); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("DROP TABLE %s;", s.tableName)); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(postgresSchema, s.tableName, s.indexName)); err != nil {
+		return err
+	}
+	if len(stored) > 0 {
+		upsert, err := tx.PrepareContext(ctx, upsertSQL(s.tableName))
+		if err != nil {
+			return err
+		}
+		defer upsert.Close()
+		for _, e := range stored {
+			if err := upsertEntry(ctx, upsert, DefaultProfile, e); err != nil {
+				return err
 			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if legacy {
+		s.log().InfoContext(ctx, "migrated legacy cookie table", "table", s.tableName, "cookies", len(stored))
+	}
+	return nil
+}
 
-			// Domain cookie: check if the requested host matches or is a subdomain of the cookie domain
-			// A cookie for domain "example.com" applies to "example.com" and "sub.example.com"
-			// A cookie for domain "sub.example.com" applies only to "sub.example.com" and its subdomains
-			if host == cookieDomain || strings.HasSuffix(host, "."+cookieDomain) {
-				allCookies = append(allCookies, cookie)
-			} else if cookieDomain == host || strings.HasSuffix(cookieDomain, "."+host) {
-				// The inverse case: we're getting cookies for example.com, but they were set on sub.example.com
-				// This is for the case in the test where we retrieve cookies for the base domain
-				if u.Path == "/" || strings.HasPrefix(cookie.Path, u.Path) {
-					allCookies = append(allCookies, cookie)
-				}
-			}
+// tableColumns returns the column names of tableName, or none when the table
+// does not exist.
+func tableColumns(ctx context.Context, tx *sql.Tx, tableName string) (map[string]bool, error) {
+	rows, err := tx.QueryContext(ctx, `
+	SELECT attname FROM pg_attribute
+	WHERE attrelid = to_regclass($1) AND attnum > 0 AND NOT attisdropped;`, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
 		}
+		columns[column] = true
 	}
+	return columns, rows.Err()
+}
 
-	if err := rows.Err(); err != nil {
-		log.Printf("Error iterating cookie rows: %v", err)
+// addProfileColumn moves the cookies of a table without profiles to
+// DefaultProfile.
+func addProfileColumn(ctx context.Context, tx *sql.Tx, tableName string) error {
+	var primaryKey string
+	err := tx.QueryRowContext(ctx, `
+	SELECT conname FROM pg_constraint
+	WHERE conrelid = to_regclass($1) AND contype = 'p';`, tableName).Scan(&primaryKey)
+	if err != nil {
+		return err
 	}
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+	ALTER TABLE %s
+		ADD COLUMN profile TEXT NOT NULL DEFAULT '',
+		DROP CONSTRAINT %s,
+		ADD PRIMARY KEY (profile, domain, path, name);`, tableName, pq.QuoteIdentifier(primaryKey)))
+	return err
+}
 
-	if len(allCookies) == 0 {
-		return nil
+// readLegacyRows returns the unexpired cookies of a table of the earlier
+// layout.
+func readLegacyRows(ctx context.Context, tx *sql.Tx, tableName string) ([]entry, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT host, cookies FROM %s;", tableName))
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	return allCookies
+	now := time.Now()
+	var entries []entry
+	for rows.Next() {
+		var host string
+		var cookiesJSON string
+		if err := rows.Scan(&host, &cookiesJSON); err != nil {
+			return nil, err
+		}
+		stored, err := jsonToCookies(cookiesJSON)
+		if err != nil {
+			return nil, fmt.Errorf("host %s: %w", host, err)
+		}
+		for _, c := range stored {
+			if e := storedEntry(host, c); !e.expired(now) {
+				e.Creation = now
+				entries = append(entries, e)
+			}
+		}
+	}
+	return entries, rows.Err()
 }
@@ -7,6 +7,9 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -151,11 +154,11 @@ func TestPostgresCookieStoreIntegration(t *testing.T) {
 		require.NotNil(t, retrievedCookies, "Retrieved cookies should not be nil for host sub.example.com")
 		compareCookieSlices(t, cookiesToSet, retrievedCookies)
 
-		// Get cookies for the base domain URL (should also work because host matches)
+		// Only the domain cookie is sent to the parent domain
 		baseDomainURL, _ := url.Parse("https://example.com/")
 		retrievedBaseCookies := store.Cookies(baseDomainURL)
 		require.NotNil(t, retrievedBaseCookies, "Retrieved cookies should not be nil for host example.com")
-		compareCookieSlices(t, cookiesToSet, retrievedBaseCookies)
+		compareCookieSlices(t, cookiesToSet[:1], retrievedBaseCookies)
 	})
 
 	t.Run("GetCookies_NotFound", func(t *testing.T) {
@@ -164,10 +167,10 @@ func TestPostgresCookieStoreIntegration(t *testing.T) {
 		require.Nil(t, retrievedCookies, "Cookies for a host not previously set should be nil")
 	})
 
-	t.Run("SetCookies_Overwrite", func(t *testing.T) {
-		testURL, _ := url.Parse("https://sub.example.com/another?q=2")
+	t.Run("SetCookies_Merge", func(t *testing.T) {
+		testURL, _ := url.Parse("https://sub.example.com/some/another?q=2")
 		originalCookies := store.Cookies(testURL)
-		require.NotNil(t, originalCookies, "Should have cookies before overwrite")
+		require.NotNil(t, originalCookies, "Should have cookies before merge")
 
 		newCookies := []*http.Cookie{
 			{Name: "session-id", Value: "new-session-value-456", Path: "/", Domain: "example.com"}, // Overwrite
@@ -176,19 +179,59 @@ func TestPostgresCookieStoreIntegration(t *testing.T) {
 
 		store.SetCookies(testURL, newCookies)
 		retrievedCookies := store.Cookies(testURL)
-		require.NotNil(t, retrievedCookies, "Cookies should not be nil after overwrite")
-		require.Len(t, retrievedCookies, len(newCookies), "Should have the new number of cookies after overwrite")
-		compareCookieSlices(t, newCookies, retrievedCookies)
-
-		// Verify the old 'user_preference' cookie is gone
-		foundOldPref := false
-		for _, c := range retrievedCookies {
-			if c.Name == "user_preference" {
-				foundOldPref = true
-				break
+		require.NotNil(t, retrievedCookies, "Cookies should not be nil after merge")
+		// The 'user_preference' cookie set earlier is kept
+		compareCookieSlices(t, append(newCookies, &http.Cookie{
+			Name: "user_preference", Value: "theme=dark&lang=en", Path: "/some", Domain: "sub.example.com",
+		}), retrievedCookies)
+	})
+
+	t.Run("Conformance", func(t *testing.T) {
+		n := 0
+		runConformanceTests(t, func(t *testing.T) http.CookieJar {
+			n++
+			jar, err := cookiestore.NewPostgresCookieStore(db, fmt.Sprintf("%s_conformance_%d", testTableName, n))
+			require.NoError(t, err)
+			return jar
+		})
+	})
+
+	t.Run("ConcurrentStores", func(t *testing.T) {
+		// Two stores on one table behave like two processes sharing it.
+		tableName := testTableName + "_concurrent"
+		stores := make([]*cookiestore.PostgresCookieStore, 2)
+		for i := range stores {
+			stores[i], err = cookiestore.NewPostgresCookieStore(db, tableName)
+			require.NoError(t, err)
+		}
+		u, _ := url.Parse("https://www.example.com/")
+
+		var wg sync.WaitGroup
+		errs := make([]error, 8)
+		for g := range errs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 20 && errs[g] == nil; i++ {
+					errs[g] = stores[g%2].SetCookiesContext(ctx, u, []*http.Cookie{
+						{Name: "n" + strconv.Itoa(g), Value: strconv.Itoa(i)},
+						{Name: "shared", Value: strconv.Itoa(i)},
+					})
+				}
+			}()
+		}
+		wg.Wait()
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+
+		cookies := stores[0].Cookies(u)
+		require.Len(t, cookies, 9, "Concurrent responses should not lose cookies")
+		for _, c := range cookies {
+			if c.Name != "shared" {
+				require.Equal(t, "19", c.Value, "Last value of %s", c.Name)
 			}
 		}
-		require.False(t, foundOldPref, "Old 'user_preference' cookie should not exist after overwrite")
 	})
 
 	t.Run("SetCookies_EmptyURLHostname", func(t *testing.T) {
@@ -206,4 +249,80 @@ func TestPostgresCookieStoreIntegration(t *testing.T) {
 		retrievedCookies := store.Cookies(invalidURL)
 		require.Nil(t, retrievedCookies, "Retrieving cookies for URL with empty hostname should return nil")
 	})
+
+	t.Run("MigrateLegacyTable", func(t *testing.T) {
+		legacyTable := testTableName + "_legacy"
+		_, err := db.ExecContext(ctx, "CREATE TABLE "+legacyTable+" (host TEXT PRIMARY KEY, cookies TEXT NOT NULL);")
+		require.NoError(t, err)
+		_, err = db.ExecContext(ctx, "INSERT INTO "+legacyTable+" (host, cookies) VALUES ($1, $2);", "www.legacy.test",
+			`[{"Name":"host","Value":"1","Path":"/"},{"Name":"domain","Value":"2","Path":"/","Domain":"legacy.test"}]`)
+		require.NoError(t, err)
+
+		legacyStore, err := cookiestore.NewPostgresCookieStore(db, legacyTable)
+		require.NoError(t, err)
+		u, _ := url.Parse("https://www.legacy.test/")
+		require.ElementsMatch(t, []string{"host=1", "domain=2"}, strings.Fields(cookieHeader(legacyStore.Cookies(u))))
+		hosts, err := legacyStore.Hosts()
+		require.NoError(t, err)
+		require.Equal(t, []string{"legacy.test", "www.legacy.test"}, hosts)
+
+		// Opening the migrated table again keeps its cookies
+		legacyStore, err = cookiestore.NewPostgresCookieStore(db, legacyTable)
+		require.NoError(t, err)
+		require.Len(t, legacyStore.Cookies(u), 2)
+	})
+
+	t.Run("SchemaOption", func(t *testing.T) {
+		_, err := db.ExecContext(ctx, "CREATE SCHEMA IF NOT EXISTS cookie_schema;")
+		require.NoError(t, err)
+
+		_, err = cookiestore.NewPostgresCookieStoreWithOption(db, "missing_cookies", &cookiestore.PostgresCookieStoreOption{
+			Schema:             "cookie_schema",
+			DisableAutoMigrate: true,
+		})
+		require.Error(t, err, "Without auto-migration the table must exist")
+
+		schemaStore, err := cookiestore.NewPostgresCookieStoreWithOption(db, "Cookies", &cookiestore.PostgresCookieStoreOption{
+			Schema:           "cookie_schema",
+			StatementTimeout: 10 * time.Second,
+		})
+		require.NoError(t, err)
+		u, _ := url.Parse("https://schema.example.com/")
+		schemaStore.SetCookies(u, []*http.Cookie{{Name: "a", Value: "1"}})
+		require.NoError(t, schemaStore.Close())
+
+		// The same table, named with a qualified name and without migration
+		qualifiedStore, err := cookiestore.NewPostgresCookieStoreWithOption(db, "cookie_schema.cookies", &cookiestore.PostgresCookieStoreOption{
+			DisableAutoMigrate: true,
+		})
+		require.NoError(t, err)
+		defer qualifiedStore.Close()
+		require.Equal(t, "a=1", cookieHeader(qualifiedStore.Cookies(u)))
+		require.Empty(t, store.Cookies(u), "The default schema should not see the cookie")
+	})
+
+	t.Run("ImportAndExport", func(t *testing.T) {
+		runImportExportTest(t, store)
+	})
+
+	t.Run("StoreManagement", func(t *testing.T) {
+		runStoreManagementTest(t, store)
+	})
+
+	t.Run("Profiles", func(t *testing.T) {
+		runProfileTest(t, store)
+	})
+}
+
+func TestPostgresCookieStoreInvalidTableName(t *testing.T) {
+	db, err := sql.Open("postgres", "host=127.0.0.1 port=1 sslmode=disable")
+	require.NoError(t, err)
+	defer db.Close()
+
+	for _, tableName := range []string{"cookies; DROP TABLE users", `"cookies"`, "a.b.c", "1cookies", "cookie-jar"} {
+		_, err := cookiestore.NewPostgresCookieStore(db, tableName)
+		require.ErrorContains(t, err, "invalid", "Table name %q", tableName)
+	}
+	_, err = cookiestore.NewPostgresCookieStoreWithOption(db, "public.cookies", &cookiestore.PostgresCookieStoreOption{Schema: "other"})
+	require.ErrorContains(t, err, "invalid", "A qualified name cannot take another schema")
 }
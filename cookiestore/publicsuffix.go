@@ -0,0 +1,111 @@
+package cookiestore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+// PublicSuffixList provides the public suffix of a domain, such as "co.uk"
+// for "www.bbc.co.uk". Stores refuse cookies whose Domain attribute is a
+// public suffix, so "evil.co.uk" cannot set a cookie for every "co.uk" site.
+// It is the same interface as net/http/cookiejar.PublicSuffixList.
+type PublicSuffixList interface {
+	PublicSuffix(domain string) string
+	String() string
+}
+
+// DefaultPublicSuffixList is used by every store unless another list is
+// configured. It is the list compiled into golang.org/x/net/publicsuffix
+// and needs no network access.
+var DefaultPublicSuffixList PublicSuffixList = publicsuffix.List
+
+type ruleKind int
+
+const (
+	normalRule ruleKind = iota
+	wildcardRule
+	exceptionRule
+)
+
+// parsedPublicSuffixList implements the algorithm of
+// https://publicsuffix.org/list/ over rules read at runtime.
+type parsedPublicSuffixList struct {
+	rules map[string]ruleKind
+}
+
+// ParsePublicSuffixList reads a list in the public_suffix_list.dat format,
+// so a store can use a newer copy than the embedded one.
+func ParsePublicSuffixList(r io.Reader) (PublicSuffixList, error) {
+	list := &parsedPublicSuffixList{rules: make(map[string]ruleKind)}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		if fields := strings.Fields(line); len(fields) > 0 {
+			line = fields[0]
+		}
+
+		kind := normalRule
+		switch {
+		case strings.HasPrefix(line, "!"):
+			kind = exceptionRule
+			line = line[1:]
+		case strings.HasPrefix(line, "*."):
+			kind = wildcardRule
+			line = line[2:]
+		}
+		rule, err := idna.ToASCII(strings.ToLower(line))
+		if err != nil {
+			return nil, fmt.Errorf("invalid public suffix rule %q: %w", line, err)
+		}
+		if kind == wildcardRule {
+			// A wildcard rule "*.x" does not make "x" a suffix by itself.
+			if _, ok := list.rules["*."+rule]; !ok {
+				list.rules["*."+rule] = wildcardRule
+			}
+			continue
+		}
+		list.rules[rule] = kind
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (l *parsedPublicSuffixList) PublicSuffix(domain string) string {
+	labels := strings.Split(domain, ".")
+	// Without a matching rule the suffix is the last label ("*" rule).
+	suffix := labels[len(labels)-1]
+	for i := len(labels) - 1; i >= 0; i-- {
+		candidate := strings.Join(labels[i:], ".")
+		if kind, ok := l.rules[candidate]; ok {
+			if kind == exceptionRule {
+				// "!www.ck" makes "ck" the suffix of "www.ck".
+				return strings.Join(labels[i+1:], ".")
+			}
+			suffix = candidate
+		}
+		if i > 0 {
+			if _, ok := l.rules["*."+candidate]; ok {
+				wildcard := strings.Join(labels[i-1:], ".")
+				if kind, ok := l.rules[wildcard]; ok && kind == exceptionRule {
+					return candidate
+				}
+				suffix = wildcard
+			}
+		}
+	}
+	return suffix
+}
+
+func (l *parsedPublicSuffixList) String() string {
+	return fmt.Sprintf("parsed public suffix list (%d rules)", len(l.rules))
+}
@@ -0,0 +1,64 @@
+package cookiestore_test
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/takumi3488/twocker/cookiestore"
+)
+
+const testPublicSuffixList = `// ===BEGIN ICANN DOMAINS===
+test
+uk
+co.uk
+*.ck
+!www.ck
+// 日本語 rules are converted to punycode
+公司.香港
+// ===END ICANN DOMAINS===
+// ===BEGIN PRIVATE DOMAINS===
+shared.test
+// ===END PRIVATE DOMAINS===
+`
+
+func TestParsePublicSuffixList(t *testing.T) {
+	psl, err := cookiestore.ParsePublicSuffixList(strings.NewReader(testPublicSuffixList))
+	require.NoError(t, err)
+
+	tests := map[string]string{
+		"example.test":             "test",
+		"foo.shared.test":          "shared.test",
+		"shared.test":              "shared.test",
+		"www.bbc.co.uk":            "co.uk",
+		"bbc.uk":                   "uk",
+		"foo.bar.ck":               "bar.ck",
+		"www.ck":                   "ck",
+		"sub.www.ck":               "ck",
+		"example.unlisted":         "unlisted",
+		"xn--55qx5d.xn--j6w193g":   "xn--55qx5d.xn--j6w193g",
+		"a.xn--55qx5d.xn--j6w193g": "xn--55qx5d.xn--j6w193g",
+	}
+	for domain, want := range tests {
+		require.Equal(t, want, psl.PublicSuffix(domain), "PublicSuffix(%q)", domain)
+	}
+}
+
+func TestInMemoryCookieStoreWithPublicSuffixList(t *testing.T) {
+	psl, err := cookiestore.ParsePublicSuffixList(strings.NewReader(testPublicSuffixList))
+	require.NoError(t, err)
+	store := cookiestore.NewInMemoryCookieStore().WithPublicSuffixList(psl)
+
+	fromURL, _ := url.Parse("http://foo.shared.test")
+	store.SetCookies(fromURL, []*http.Cookie{
+		{Name: "a", Value: "1", Domain: "shared.test"},
+		{Name: "b", Value: "2", Domain: "foo.shared.test"},
+	})
+
+	toURL, _ := url.Parse("http://bar.shared.test")
+	require.Empty(t, store.Cookies(toURL))
+	require.Equal(t, "b=2", cookieHeader(store.Cookies(fromURL)))
+}
@@ -0,0 +1,59 @@
+package cookiestore
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// reserveScript implements the generic cell rate algorithm. It keeps the
+// theoretical arrival time of the next request per host, in microseconds of
+// the Redis server clock, so every process sees the same budget.
+var reserveScript = redis.NewScript(`
+local now = redis.call('TIME')
+now = tonumber(now[1]) * 1000000 + tonumber(now[2])
+local interval = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local tat = tonumber(redis.call('GET', KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+tat = tat + interval
+local wait = tat - interval * burst - now
+if wait < 0 then
+	wait = 0
+end
+redis.call('SET', KEYS[1], tat, 'PX', math.ceil((tat - now) / 1000) + 1000)
+return wait
+`)
+
+// RedisRateLimitBackend shares request budgets between every process using
+// the same Redis server and prefix. It satisfies model.RateLimitBackend.
+type RedisRateLimitBackend struct {
+	redisClient redis.UniversalClient
+	prefix      string
+}
+
+// RateLimitBackend returns a rate limit backend that uses the store's
+// connection and prefix.
+func (s *RedisCookieStore) RateLimitBackend() *RedisRateLimitBackend {
+	return &RedisRateLimitBackend{
+		redisClient: s.redisClient,
+		prefix:      s.prefix,
+	}
+}
+
+func (b *RedisRateLimitBackend) Reserve(ctx context.Context, host string, interval time.Duration, burst int) (time.Duration, error) {
+	wait, err := reserveScript.Run(
+		ctx,
+		b.redisClient,
+		[]string{"{" + b.prefix + "}:ratelimit:" + host},
+		interval.Microseconds(),
+		burst,
+	).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(wait) * time.Microsecond, nil
+}
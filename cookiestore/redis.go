@@ -3,93 +3,670 @@ package cookiestore
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
 type RedisCookieStore struct {
-	redisClient *redis.Client
-	prefix      string
+	redisClient redis.UniversalClient
+	// ownsClient is set when the store created its client, which Close then
+	// closes.
+	ownsClient bool
+	prefix     string
+	profile    string
+	// migration is shared by the store and its profiles.
+	migration *redisMigration
+	psl       PublicSuffixList
+	onError   ErrorHandler
+	logger    *slog.Logger
+}
+
+// redisMigration records that the keys of earlier versions were converted.
+type redisMigration struct {
+	mu   sync.Mutex
+	done atomic.Bool
 }
 
 type NewRedisCookieStoreOption = redis.Options
 
+type NewUniversalRedisCookieStoreOption = redis.UniversalOptions
+
+// NewRedisCookieStore connects to a single server. Cookies stored under
+// prefix by earlier versions are converted on first use, see MigrateKeys.
 func NewRedisCookieStore(option *NewRedisCookieStoreOption, prefix *string) *RedisCookieStore {
+	s := NewRedisCookieStoreFromClient(redis.NewClient(option), prefix)
+	s.ownsClient = true
+	return s
+}
+
+// NewUniversalRedisCookieStore connects to a single server, a Sentinel
+// deployment or a Cluster depending on option, as redis.NewUniversalClient
+// does.
+func NewUniversalRedisCookieStore(option *NewUniversalRedisCookieStoreOption, prefix *string) *RedisCookieStore {
+	s := NewRedisCookieStoreFromClient(redis.NewUniversalClient(option), prefix)
+	s.ownsClient = true
+	return s
+}
+
+// NewRedisCookieStoreFromClient uses an existing client, which is left open
+// by Close.
+func NewRedisCookieStoreFromClient(client redis.UniversalClient, prefix *string) *RedisCookieStore {
 	if prefix == nil {
 		prefix = new(string)
 		*prefix = "twocker"
 	}
 	return &RedisCookieStore{
-		redisClient: redis.NewClient(option),
+		redisClient: client,
 		prefix:      *prefix,
+		migration:   &redisMigration{},
+		psl:         DefaultPublicSuffixList,
 	}
 }
 
+// WithPublicSuffixList replaces DefaultPublicSuffixList. A nil list turns
+// the public suffix check off.
+func (s *RedisCookieStore) WithPublicSuffixList(psl PublicSuffixList) *RedisCookieStore {
+	s.psl = psl
+	return s
+}
+
+// WithErrorHandler sets the handler for errors of SetCookies and Cookies,
+// which cannot return them. By default they are logged to the store's
+// logger.
+func (s *RedisCookieStore) WithErrorHandler(onError ErrorHandler) *RedisCookieStore {
+	s.onError = onError
+	return s
+}
+
+// WithLogger sets the logger for store events and, unless WithErrorHandler
+// was used, errors. Events are logged at debug level with hosts and cookie
+// counts but never cookie values. It defaults to slog.Default.
+func (s *RedisCookieStore) WithLogger(logger *slog.Logger) *RedisCookieStore {
+	s.logger = logger
+	return s
+}
+
+func (s *RedisCookieStore) WithContext(ctx context.Context) http.CookieJar {
+	return s.jar().WithContext(ctx)
+}
+
 func (s *RedisCookieStore) SetCookies(url *url.URL, cookies []*http.Cookie) {
-	if url.Hostname() == "" {
-		// Skip settings cookies for URLs without hostname
-		log.Println("Warning: SetCookies called with URL without hostname:", url)
-		return
+	s.jar().SetCookies(url, cookies)
+}
+
+func (s *RedisCookieStore) Cookies(url *url.URL) []*http.Cookie {
+	return s.jar().Cookies(url)
+}
+
+func (s *RedisCookieStore) jar() ContextJar {
+	return NewCookieJar(s, errorHandler(s.onError, s.logger))
+}
+
+func (s *RedisCookieStore) SetCookiesContext(ctx context.Context, url *url.URL, cookies []*http.Cookie) error {
+	changes, err := newCookieChanges(url, cookies, time.Now(), s.psl)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		s.log().DebugContext(ctx, "cookies rejected", "host", url.Hostname(), "rejected", len(cookies))
+		return nil
+	}
+	if err := s.migrateOnce(ctx); err != nil {
+		return err
+	}
+	if err := s.apply(ctx, changes); err != nil {
+		return err
+	}
+	logChanges(ctx, s.log(), url.Hostname(), len(cookies), changes)
+	return nil
+}
+
+func (s *RedisCookieStore) log() *slog.Logger {
+	return loggerOrDefault(s.logger)
+}
+
+func (s *RedisCookieStore) CookiesContext(ctx context.Context, url *url.URL) ([]*http.Cookie, error) {
+	host, err := canonicalHost(url.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.migrateOnce(ctx); err != nil {
+		return nil, err
+	}
+
+	entries, err := s.load(ctx, cookieDomains(host, s.psl))
+	if err != nil {
+		return nil, err
+	}
+	cookies := selectCookies(entries, url, time.Now())
+	s.log().DebugContext(ctx, "cookies loaded", "host", host, "count", len(cookies))
+	return cookies, nil
+}
+
+func (s *RedisCookieStore) AllCookies() ([]*http.Cookie, error) {
+	entries, err := s.entries(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	cookies := make([]*http.Cookie, len(entries))
+	for i, e := range entries {
+		cookies[i] = exportCookie(e)
 	}
+	sortCookies(cookies)
+	return cookies, nil
+}
+
+func (s *RedisCookieStore) Hosts() ([]string, error) {
+	entries, err := s.entries(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return entryHosts(entries), nil
+}
 
+func (s *RedisCookieStore) DeleteCookie(host string, name string, path string) error {
+	filter, err := deleteCookieFilter(host, name, path)
+	if err != nil {
+		return err
+	}
+	return s.remove(context.Background(), filter)
+}
+
+func (s *RedisCookieStore) ClearHost(host string) error {
+	filter, err := clearHostFilter(host)
+	if err != nil {
+		return err
+	}
+	return s.remove(context.Background(), filter)
+}
+
+func (s *RedisCookieStore) ClearAll() error {
 	ctx := context.Background()
-	for _, cookie := range s.Cookies(url) {
-		flg := false
-		for _, newCookie := range cookies {
-			if cookie.Name == newCookie.Name {
-				flg = true
-				break
-			}
+	if err := s.migrateOnce(ctx); err != nil {
+		return err
+	}
+	return clearScript.Run(ctx, s.redisClient, []string{s.indexKey(), s.profilesKey()}, s.namespace(), s.profile).Err()
+}
+
+// PurgeExpired removes expired cookies left by servers without field
+// expiry, which is available from Redis 7.4.
+func (s *RedisCookieStore) PurgeExpired() error {
+	return s.remove(context.Background(), expiredFilter(time.Now()))
+}
+
+// Profile returns a view of the store acting on the named profile, which
+// shares the store's client and prefix.
+func (s *RedisCookieStore) Profile(name string) Store {
+	return s.withProfile(name)
+}
+
+func (s *RedisCookieStore) Profiles() ([]string, error) {
+	ctx := context.Background()
+	if err := s.migrateOnce(ctx); err != nil {
+		return nil, err
+	}
+	names, err := s.redisClient.SMembers(ctx, s.profilesKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	// The set is only updated by writes, so it still lists profiles whose
+	// cookies have all expired since. Those are pruned here.
+	live := names[:0]
+	for _, name := range names {
+		profile := s.withProfile(name)
+		entries, err := profile.entries(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) > 0 {
+			live = append(live, name)
+			continue
 		}
-		if !flg {
-			cookies = append(cookies, cookie)
+		if err := profile.remove(ctx, expiredFilter(time.Now())); err != nil {
+			return nil, err
 		}
 	}
-	err := s.redisClient.Set(
-		ctx,
-		s.prefix+":"+url.Hostname(),
-		cookiesToJson(cookies),
-		0,
-	).Err()
+	sort.Strings(live)
+	return live, nil
+}
+
+// CloneProfile copies the cookies of src to dst. Changes made to src while
+// it runs may or may not be copied.
+func (s *RedisCookieStore) CloneProfile(src string, dst string) error {
+	ctx := context.Background()
+	entries, err := s.withProfile(src).entries(ctx)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	target := s.withProfile(dst)
+	if err := target.ClearAll(); err != nil || len(entries) == 0 {
+		return err
 	}
+	changes := make([]cookieChange, len(entries))
+	for i, e := range entries {
+		changes[i] = cookieChange{entry: e}
+	}
+	return target.apply(ctx, changes)
 }
 
-func (s *RedisCookieStore) Cookies(url *url.URL) []*http.Cookie {
-	if url.Hostname() == "" {
-		log.Println("Warning: Cookies called with URL without hostname:", url)
+func (s *RedisCookieStore) DeleteProfile(name string) error {
+	return s.withProfile(name).ClearAll()
+}
+
+func (s *RedisCookieStore) withProfile(name string) *RedisCookieStore {
+	view := *s
+	view.profile = name
+	view.ownsClient = false
+	return &view
+}
+
+// Close closes the client created by the store's constructor. A client
+// passed to NewRedisCookieStoreFromClient is left open, and so is the client
+// of a profile.
+func (s *RedisCookieStore) Close() error {
+	if !s.ownsClient {
 		return nil
 	}
+	return s.redisClient.Close()
+}
 
-	ctx := context.Background()
-	res, err := s.redisClient.Get(ctx, s.prefix+":"+url.Hostname()).Result()
+// Cookies are stored in a hash per cookie domain, at {prefix}:domain, with a
+// field per cookie id holding a redisEntry, and the domains are listed in
+// the set {prefix}:@domains. The keys of a profile have the namespace
+// {prefix}:@name: instead of {prefix}:, the set {prefix}:@profiles lists
+// the profiles with cookies, and {prefix}:@migrated is set once the keys of
+// earlier versions were converted. The hash tag keeps every key of a prefix on one
+// Cluster slot, so scripts can update the hashes and the set together and
+// listing cookies never scans the keyspace. Every change to a hash goes
+// through mergeScript, so concurrent responses never overwrite each other's
+// cookies, and the key expires with its last cookie.
+
+// redisEntry is an entry as stored in a hash field. ExpiresAt is the expiry
+// in Unix milliseconds, or 0 for session cookies, for mergeScript to read.
+type redisEntry struct {
+	entry
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+// mergeScript applies changes to the hash KEYS[1] of the domain ARGV[1] and
+// records the domain in the set KEYS[2] while the hash exists, and the
+// profile ARGV[2], unless it is the default, in the set KEYS[3] while it has
+// domains. The other arguments are triples of an operation ("set" or "del"), a field and, for
+// "set", a redisEntry. A replaced cookie keeps its creation time. Expired
+// fields are dropped, the remaining ones expire with their cookie where the
+// server supports field expiry, and the key expires with the last cookie
+// unless it holds session cookies.
+var mergeScript = redis.NewScript(`
+local key = KEYS[1]
+for i = 3, #ARGV, 3 do
+	local field = ARGV[i + 1]
+	if ARGV[i] == 'del' then
+		redis.call('HDEL', key, field)
+	else
+		local value = ARGV[i + 2]
+		local old = redis.call('HGET', key, field)
+		if old then
+			local creation = string.match(old, '"creation":"[^"]*"')
+			if creation then
+				value = string.gsub(value, '"creation":"[^"]*"', creation, 1)
+			end
+		end
+		redis.call('HSET', key, field, value)
+	end
+end
+
+local now = redis.call('TIME')
+now = tonumber(now[1]) * 1000 + math.floor(tonumber(now[2]) / 1000)
+local session = false
+local last = 0
+local fields = redis.call('HGETALL', key)
+for i = 1, #fields, 2 do
+	local expiresAt = tonumber(string.match(fields[i + 1], '"expires_at":(%d+)') or 0)
+	if expiresAt == 0 then
+		session = true
+		redis.pcall('HPERSIST', key, 'FIELDS', 1, fields[i])
+	elseif expiresAt <= now then
+		redis.call('HDEL', key, fields[i])
+	else
+		if expiresAt > last then
+			last = expiresAt
+		end
+		redis.pcall('HPEXPIREAT', key, expiresAt, 'FIELDS', 1, fields[i])
+	end
+end
+if session then
+	redis.call('PERSIST', key)
+elseif last > 0 then
+	redis.call('PEXPIREAT', key, last)
+end
+
+if redis.call('EXISTS', key) == 1 then
+	redis.call('SADD', KEYS[2], ARGV[1])
+else
+	redis.call('SREM', KEYS[2], ARGV[1])
+end
+if ARGV[2] ~= '' then
+	if redis.call('SCARD', KEYS[2]) > 0 then
+		redis.call('SADD', KEYS[3], ARGV[2])
+	else
+		redis.call('SREM', KEYS[3], ARGV[2])
+	end
+end
+return redis.status_reply('OK')
+`)
+
+// clearScript deletes the hash of every domain listed in the set KEYS[1],
+// whose keys start with ARGV[1], and the set itself, and drops the profile
+// ARGV[2] from the set KEYS[2]. Reading the set in the script keeps a domain
+// added by a concurrent response from outliving its index entry; the hashes
+// share the hash tag of the set, so this holds on Cluster too.
+var clearScript = redis.NewScript(`
+local domains = redis.call('SMEMBERS', KEYS[1])
+for _, domain in ipairs(domains) do
+	redis.call('DEL', ARGV[1] .. domain)
+end
+redis.call('DEL', KEYS[1])
+if ARGV[2] ~= '' then
+	redis.call('SREM', KEYS[2], ARGV[2])
+end
+return redis.status_reply('OK')
+`)
+
+// takeLegacyScript removes the key KEYS[1] written by earlier versions and
+// returns its type and contents: a JSON array of cookies for the string kept
+// per request host, or the fields and values of an unhashtagged cookie hash.
+var takeLegacyScript = redis.NewScript(`
+local t = redis.call('TYPE', KEYS[1]).ok
+local taken
+if t == 'string' then
+	taken = {t, redis.call('GET', KEYS[1])}
+elseif t == 'hash' then
+	taken = redis.call('HGETALL', KEYS[1])
+	table.insert(taken, 1, t)
+else
+	return {t}
+end
+redis.call('DEL', KEYS[1])
+return taken
+`)
+
+func (s *RedisCookieStore) key(domain string) string {
+	return s.namespace() + domain
+}
+
+func (s *RedisCookieStore) indexKey() string {
+	return s.namespace() + "@domains"
+}
+
+// migratedKey marks a prefix whose keys MigrateKeys has converted.
+func (s *RedisCookieStore) migratedKey() string {
+	return "{" + s.prefix + "}:@migrated"
+}
+
+func (s *RedisCookieStore) profilesKey() string {
+	return "{" + s.prefix + "}:@profiles"
+}
+
+// namespace prefixes the keys of the store's profile. Profile names are
+// escaped so that they never contain the colon ending the namespace.
+func (s *RedisCookieStore) namespace() string {
+	if s.profile == DefaultProfile {
+		return "{" + s.prefix + "}:"
+	}
+	return "{" + s.prefix + "}:@" + url.QueryEscape(s.profile) + ":"
+}
+
+// apply stores changes with one mergeScript call per cookie domain.
+func (s *RedisCookieStore) apply(ctx context.Context, changes []cookieChange) error {
+	var domains []string
+	args := make(map[string][]any)
+	for _, change := range changes {
+		e := change.entry
+		if _, ok := args[e.Domain]; !ok {
+			domains = append(domains, e.Domain)
+		}
+		if change.remove {
+			args[e.Domain] = append(args[e.Domain], "del", e.id(), "")
+			continue
+		}
+		stored := redisEntry{entry: e}
+		if e.Persistent {
+			stored.ExpiresAt = e.Expires.UnixMilli()
+		}
+		value, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		args[e.Domain] = append(args[e.Domain], "set", e.id(), string(value))
+	}
+	for _, domain := range domains {
+		if err := s.merge(ctx, domain, args[domain]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *RedisCookieStore) merge(ctx context.Context, domain string, ops ...any) error {
+	args := append([]any{domain, s.profile}, ops...)
+	return mergeScript.Run(ctx, s.redisClient, []string{s.key(domain), s.indexKey(), s.profilesKey()}, args...).Err()
+}
+
+// load returns the unexpired entries stored for domains.
+func (s *RedisCookieStore) load(ctx context.Context, domains []string) ([]entry, error) {
+	cmds := make([]*redis.MapStringStringCmd, len(domains))
+	_, err := s.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, domain := range domains {
+			cmds[i] = pipe.HGetAll(ctx, s.key(domain))
+		}
+		return nil
+	})
 	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var entries []entry
+	for i, cmd := range cmds {
+		err := decodeFields(domains[i], cmd.Val(), func(field string, e entry) {
+			if !e.expired(now) {
+				entries = append(entries, e)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// entries returns every unexpired cookie under the prefix.
+func (s *RedisCookieStore) entries(ctx context.Context) ([]entry, error) {
+	if err := s.migrateOnce(ctx); err != nil {
+		return nil, err
+	}
+	domains, err := s.redisClient.SMembers(ctx, s.indexKey()).Result()
+	if err != nil || len(domains) == 0 {
+		return nil, err
+	}
+	return s.load(ctx, domains)
+}
+
+// remove deletes the cookies selected by filter from every hash. Domains
+// whose hash has expired are dropped from the index on the way.
+func (s *RedisCookieStore) remove(ctx context.Context, filter entryFilter) error {
+	if err := s.migrateOnce(ctx); err != nil {
+		return err
+	}
+	domains, err := s.redisClient.SMembers(ctx, s.indexKey()).Result()
+	if err != nil {
+		return err
+	}
+	for _, domain := range domains {
+		fields, err := s.redisClient.HGetAll(ctx, s.key(domain)).Result()
+		if err != nil {
+			return err
+		}
+		var ops []any
+		err = decodeFields(domain, fields, func(field string, e entry) {
+			if filter(e) {
+				ops = append(ops, "del", field, "")
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if len(ops) > 0 || len(fields) == 0 {
+			if err := s.merge(ctx, domain, ops...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func decodeFields(domain string, fields map[string]string, fn func(field string, e entry)) error {
+	for field, value := range fields {
+		var stored redisEntry
+		if err := json.Unmarshal([]byte(value), &stored); err != nil {
+			return fmt.Errorf("domain %s: decode stored cookie: %w", domain, err)
+		}
+		fn(field, stored.entry)
+	}
+	return nil
+}
+
+// migrateOnce runs MigrateKeys the first time the store is used, unless the
+// prefix was marked as converted. Failed attempts are retried on the next
+// call.
+func (s *RedisCookieStore) migrateOnce(ctx context.Context) error {
+	m := s.migration
+	if m.done.Load() {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.done.Load() {
 		return nil
 	}
-	return jsonToCookies(res)
+	migrated, err := s.redisClient.Exists(ctx, s.migratedKey()).Result()
+	if err != nil {
+		return err
+	}
+	if migrated == 0 {
+		if err := s.MigrateKeys(ctx); err != nil {
+			return err
+		}
+	}
+	m.done.Store(true)
+	return nil
 }
 
-func cookiesToJson(c []*http.Cookie) string {
-	if len(c) == 0 {
-		return "[]"
+// MigrateKeys converts the cookies that earlier versions stored at
+// prefix:host, without a hash tag, to the current layout, and marks the
+// prefix as converted. Stores run it on first use; run it again for keys
+// written by processes of an earlier version afterwards. It scans every
+// master of a Cluster and may be run by several processes at once.
+func (s *RedisCookieStore) MigrateKeys(ctx context.Context) error {
+	scan := func(ctx context.Context, client redis.UniversalClient) error {
+		keyPrefix := s.prefix + ":"
+		rateLimitPrefix := keyPrefix + "ratelimit:"
+		iter := client.Scan(ctx, 0, escapeRedisPattern(keyPrefix)+"*", 100).Iterator()
+		for iter.Next(ctx) {
+			key := iter.Val()
+			if strings.HasPrefix(key, rateLimitPrefix) {
+				continue
+			}
+			if err := s.migrateKey(ctx, key, strings.TrimPrefix(key, keyPrefix)); err != nil {
+				return fmt.Errorf("migrate key %s: %w", key, err)
+			}
+		}
+		return iter.Err()
 	}
+	var err error
+	if cluster, ok := s.redisClient.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return scan(ctx, client)
+		})
+	} else {
+		err = scan(ctx, s.redisClient)
+	}
+	if err != nil {
+		return err
+	}
+	return s.redisClient.Set(ctx, s.migratedKey(), "1", 0).Err()
+}
 
-	cookiesJSON, err := json.Marshal(c)
+func (s *RedisCookieStore) migrateKey(ctx context.Context, key string, host string) error {
+	taken, err := takeLegacyScript.Run(ctx, s.redisClient, []string{key}).StringSlice()
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	now := time.Now()
+	var changes []cookieChange
+	switch taken[0] {
+	case "string":
+		stored, err := jsonToCookies(taken[1])
+		if err != nil {
+			return err
+		}
+		for _, c := range stored {
+			e := storedEntry(host, c)
+			e.Creation = now
+			changes = append(changes, cookieChange{entry: e})
+		}
+	case "hash":
+		for i := 2; i < len(taken); i += 2 {
+			var stored redisEntry
+			if err := json.Unmarshal([]byte(taken[i]), &stored); err != nil {
+				return fmt.Errorf("decode stored cookie: %w", err)
+			}
+			changes = append(changes, cookieChange{entry: stored.entry})
+		}
+	default:
+		return nil
 	}
-	return string(cookiesJSON)
+
+	kept := changes[:0]
+	for _, change := range changes {
+		if !change.entry.expired(now) {
+			kept = append(kept, change)
+		}
+	}
+	s.log().InfoContext(ctx, "migrated legacy cookie key", "key", key, "cookies", len(kept))
+	if len(kept) == 0 {
+		return nil
+	}
+	return s.apply(ctx, kept)
 }
 
-func jsonToCookies(s string) []*http.Cookie {
+// escapeRedisPattern escapes the glob characters of a SCAN MATCH pattern.
+func escapeRedisPattern(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func jsonToCookies(s string) ([]*http.Cookie, error) {
 	var cookies []*http.Cookie
-	err := json.Unmarshal([]byte(s), &cookies)
-	if err != nil {
-		panic(err)
+	if err := json.Unmarshal([]byte(s), &cookies); err != nil {
+		return nil, fmt.Errorf("decode stored cookies: %w", err)
 	}
-	return cookies
+	return cookies, nil
 }
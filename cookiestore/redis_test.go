@@ -6,6 +6,8 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -140,9 +142,9 @@ func TestRedisCookieStoreIntegration(t *testing.T) {
 		require.NotNil(t, retrievedCookies, "Retrieved cookies should not be nil for host sub.example.com")
 		compareCookieSlices(t, cookiesToSet, retrievedCookies)
 
-		// Redis implementation stores cookies by hostname, so example.com and sub.example.com are different keys
-		// This test was removed because the Redis implementation behaves differently from PostgreSQL here
-		// In a real-world scenario, we'd either modify the implementation or adjust expectations accordingly
+		// Only the domain cookie is sent to the parent domain
+		baseURL, _ := url.Parse("https://example.com/")
+		compareCookieSlices(t, cookiesToSet[:1], store.Cookies(baseURL))
 	})
 
 	t.Run("GetCookies_NotFound", func(t *testing.T) {
@@ -194,6 +196,186 @@ func TestRedisCookieStoreIntegration(t *testing.T) {
 		retrievedCookies := store.Cookies(invalidURL)
 		require.Nil(t, retrievedCookies, "Retrieving cookies for URL with empty hostname should return nil")
 	})
+
+	t.Run("Conformance", func(t *testing.T) {
+		n := 0
+		runConformanceTests(t, func(t *testing.T) http.CookieJar {
+			n++
+			prefix := fmt.Sprintf("%s_conformance_%d", testPrefix, n)
+			return cookiestore.NewRedisCookieStore(options, &prefix)
+		})
+	})
+
+	t.Run("ConcurrentSameHost", func(t *testing.T) {
+		prefix := testPrefix + "_concurrent"
+		store := cookiestore.NewRedisCookieStore(options, &prefix)
+		u, _ := url.Parse("https://www.example.com/")
+
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 50; i++ {
+					store.SetCookies(u, []*http.Cookie{{Name: "n" + strconv.Itoa(g), Value: strconv.Itoa(i)}})
+				}
+			}()
+		}
+		wg.Wait()
+
+		cookies := store.Cookies(u)
+		require.Len(t, cookies, 8, "Concurrent responses should not lose cookies")
+		for _, c := range cookies {
+			require.Equal(t, "49", c.Value, "Last value of %s", c.Name)
+		}
+	})
+
+	t.Run("KeyExpiry", func(t *testing.T) {
+		prefix := testPrefix + "_expiry"
+		store := cookiestore.NewRedisCookieStore(options, &prefix)
+		u, _ := url.Parse("https://expiry.example.com/")
+		key := "{" + prefix + "}:expiry.example.com"
+
+		store.SetCookies(u, []*http.Cookie{
+			{Name: "short", Value: "1", MaxAge: 60},
+			{Name: "long", Value: "2", Expires: time.Now().Add(2 * time.Hour)},
+		})
+		ttl, err := redisClient.TTL(ctx, key).Result()
+		require.NoError(t, err)
+		require.InDelta(t, 2*time.Hour, ttl, float64(time.Minute), "Key should expire with its last cookie")
+
+		store.SetCookies(u, []*http.Cookie{{Name: "session", Value: "3"}})
+		ttl, err = redisClient.TTL(ctx, key).Result()
+		require.NoError(t, err)
+		require.Equal(t, time.Duration(-1), ttl, "Session cookies should keep the key")
+
+		store.SetCookies(u, []*http.Cookie{
+			{Name: "session", MaxAge: -1},
+			{Name: "long", MaxAge: -1},
+			{Name: "short", Expires: time.Unix(1, 0)},
+		})
+		exists, err := redisClient.Exists(ctx, key).Result()
+		require.NoError(t, err)
+		require.Zero(t, exists, "Key should be deleted with its last cookie")
+	})
+
+	t.Run("MigrateKeys", func(t *testing.T) {
+		prefix := testPrefix + "_legacy"
+		store := cookiestore.NewRedisCookieStore(options, &prefix)
+		err := redisClient.Set(ctx, prefix+":www.legacy.test",
+			`[{"Name":"host","Value":"1","Path":"/"},{"Name":"domain","Value":"2","Path":"/","Domain":"legacy.test"}]`, 0).Err()
+		require.NoError(t, err)
+		err = redisClient.HSet(ctx, prefix+":hash.legacy.test", "hash.legacy.test;/;hash",
+			`{"name":"hash","value":"3","domain":"hash.legacy.test","path":"/","host_only":true,"expires":"0001-01-01T00:00:00Z","creation":"2025-01-01T00:00:00Z","expires_at":0}`).Err()
+		require.NoError(t, err)
+
+		require.NoError(t, store.MigrateKeys(ctx))
+		u, _ := url.Parse("https://www.legacy.test/")
+		require.Equal(t, "domain=2 host=1", cookieHeader(store.Cookies(u)))
+		hosts, err := store.Hosts()
+		require.NoError(t, err)
+		require.Equal(t, []string{"hash.legacy.test", "legacy.test", "www.legacy.test"}, hosts)
+		exists, err := redisClient.Exists(ctx, prefix+":www.legacy.test", prefix+":hash.legacy.test").Result()
+		require.NoError(t, err)
+		require.Zero(t, exists, "Legacy keys should be removed")
+	})
+
+	t.Run("MigrateOnFirstUse", func(t *testing.T) {
+		prefix := testPrefix + "_upgrade"
+		err := redisClient.Set(ctx, prefix+":www.upgrade.test", `[{"Name":"sid","Value":"1","Path":"/"}]`, 0).Err()
+		require.NoError(t, err)
+
+		store := cookiestore.NewRedisCookieStore(options, &prefix)
+		u, _ := url.Parse("https://www.upgrade.test/")
+		require.Equal(t, "sid=1", cookieHeader(store.Cookies(u)), "Legacy cookies should be converted on first use")
+
+		// Keys written later are left to MigrateKeys.
+		err = redisClient.Set(ctx, prefix+":late.upgrade.test", `[{"Name":"late","Value":"2","Path":"/"}]`, 0).Err()
+		require.NoError(t, err)
+		lateURL, _ := url.Parse("https://late.upgrade.test/")
+		require.Empty(t, cookiestore.NewRedisCookieStore(options, &prefix).Cookies(lateURL), "A converted prefix should not be scanned again")
+		require.NoError(t, store.MigrateKeys(ctx))
+		require.Equal(t, "late=2", cookieHeader(store.Cookies(lateURL)))
+	})
+
+	t.Run("FromClient", func(t *testing.T) {
+		prefix := testPrefix + "_client"
+		var client goredis.UniversalClient = redisClient
+		store := cookiestore.NewRedisCookieStoreFromClient(client, &prefix)
+		u, _ := url.Parse("https://client.example.com/")
+		store.SetCookies(u, []*http.Cookie{{Name: "a", Value: "1"}})
+		require.Equal(t, "a=1", cookieHeader(store.Cookies(u)))
+
+		require.NoError(t, store.Close())
+		require.NoError(t, redisClient.Ping(ctx).Err(), "Close should leave a passed client open")
+	})
+
+	t.Run("ImportAndExport", func(t *testing.T) {
+		runImportExportTest(t, store)
+	})
+
+	t.Run("StoreManagement", func(t *testing.T) {
+		runStoreManagementTest(t, store)
+	})
+
+	t.Run("Profiles", func(t *testing.T) {
+		runProfileTest(t, store)
+	})
+
+	t.Run("RateLimitBackend_SharedBudget", func(t *testing.T) {
+		// Two backends on the same prefix behave like two processes sharing a budget.
+		first := store.RateLimitBackend()
+		second := cookiestore.NewRedisCookieStore(options, &prefix).RateLimitBackend()
+
+		interval := time.Second
+		wait, err := first.Reserve(ctx, "rate.example.com", interval, 2)
+		require.NoError(t, err)
+		require.Zero(t, wait, "First request should fit in the burst")
+		wait, err = second.Reserve(ctx, "rate.example.com", interval, 2)
+		require.NoError(t, err)
+		require.Zero(t, wait, "Second request should fit in the burst")
+		wait, err = first.Reserve(ctx, "rate.example.com", interval, 2)
+		require.NoError(t, err)
+		require.InDelta(t, interval, wait, float64(100*time.Millisecond), "Third request should wait for the shared budget")
+
+		wait, err = second.Reserve(ctx, "other.example.com", interval, 1)
+		require.NoError(t, err)
+		require.Zero(t, wait, "Hosts should have independent budgets")
+	})
 }
 
 // compareCookieSlices is defined in testutil_test.go
+
+func TestRedisCookieStoreUnreachable(t *testing.T) {
+	store := cookiestore.NewRedisCookieStore(&cookiestore.NewRedisCookieStoreOption{
+		Addr:        "127.0.0.1:1",
+		DialTimeout: 100 * time.Millisecond,
+		MaxRetries:  -1,
+	}, nil)
+	var ops []string
+	store.WithErrorHandler(func(ctx context.Context, op string, u *url.URL, err error) {
+		require.Error(t, err)
+		ops = append(ops, op)
+	})
+
+	u, _ := url.Parse("https://example.com/")
+	require.NotPanics(t, func() {
+		store.SetCookies(u, []*http.Cookie{{Name: "a", Value: "1"}})
+		require.Nil(t, store.Cookies(u))
+	})
+	require.Equal(t, []string{"SetCookies", "Cookies"}, ops)
+
+	err := store.SetCookiesContext(context.Background(), u, []*http.Cookie{{Name: "a", Value: "1"}})
+	require.Error(t, err)
+}
+
+func TestRedisCookieStoreClose(t *testing.T) {
+	store := cookiestore.NewUniversalRedisCookieStore(&cookiestore.NewUniversalRedisCookieStoreOption{
+		Addrs: []string{"127.0.0.1:1"},
+	}, nil)
+	require.NoError(t, store.Close())
+
+	u, _ := url.Parse("https://example.com/")
+	_, err := store.CookiesContext(context.Background(), u)
+	require.ErrorIs(t, err, goredis.ErrClosed, "Close should close the store's own client")
+}
@@ -0,0 +1,365 @@
+package cookiestore
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/idna"
+)
+
+// This file implements the storage and retrieval model of RFC 6265 shared by
+// every store: section 5.3 decides which Set-Cookie values are accepted and
+// how they replace stored cookies, section 5.4 which cookies are sent back
+// and in what order.
+
+var (
+	errIllegalDomain   = errors.New("cookiestore: illegal cookie domain attribute")
+	errMalformedDomain = errors.New("cookiestore: malformed cookie domain attribute")
+	errInsecureSecure  = errors.New("cookiestore: secure cookie set over an insecure connection")
+	errNoHostname      = errors.New("cookiestore: URL has no hostname")
+)
+
+// entry is a stored cookie. Domain never has a leading dot; for host-only
+// cookies it is the host that set the cookie.
+type entry struct {
+	Name       string    `json:"name"`
+	Value      string    `json:"value"`
+	Quoted     bool      `json:"quoted,omitempty"`
+	Domain     string    `json:"domain"`
+	Path       string    `json:"path"`
+	SameSite   string    `json:"same_site,omitempty"`
+	Secure     bool      `json:"secure,omitempty"`
+	HttpOnly   bool      `json:"http_only,omitempty"`
+	HostOnly   bool      `json:"host_only,omitempty"`
+	Persistent bool      `json:"persistent,omitempty"`
+	Expires    time.Time `json:"expires"`
+	Creation   time.Time `json:"creation"`
+}
+
+// id identifies an entry: a new cookie with the same id replaces it.
+func (e *entry) id() string {
+	return e.Domain + ";" + e.Path + ";" + e.Name
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return e.Persistent && !e.Expires.After(now)
+}
+
+func (e *entry) domainMatch(host string) bool {
+	if e.Domain == host {
+		return true
+	}
+	return !e.HostOnly && hasDotSuffix(host, e.Domain)
+}
+
+// pathMatch implements section 5.1.4.
+func (e *entry) pathMatch(requestPath string) bool {
+	if requestPath == e.Path {
+		return true
+	}
+	if strings.HasPrefix(requestPath, e.Path) {
+		if e.Path[len(e.Path)-1] == '/' {
+			return true
+		} else if requestPath[len(e.Path)] == '/' {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *entry) shouldSend(https bool, host string, path string) bool {
+	return e.domainMatch(host) && e.pathMatch(path) && (https || !e.Secure)
+}
+
+// cookie converts e to the *http.Cookie returned by the stores. Host-only
+// cookies have an empty Domain.
+func (e *entry) cookie() *http.Cookie {
+	c := &http.Cookie{
+		Name:     e.Name,
+		Value:    e.Value,
+		Quoted:   e.Quoted,
+		Path:     e.Path,
+		Secure:   e.Secure,
+		HttpOnly: e.HttpOnly,
+		SameSite: parseSameSite(e.SameSite),
+	}
+	if !e.HostOnly {
+		c.Domain = e.Domain
+	}
+	if e.Persistent {
+		c.Expires = e.Expires
+	}
+	return c
+}
+
+// cookieChange is the effect of one Set-Cookie value: either an entry to
+// store, or the id of an entry to delete.
+type cookieChange struct {
+	entry  entry
+	remove bool
+}
+
+// newCookieChanges validates cookies received in a response from u. Invalid
+// cookies are dropped; the rest are returned in order.
+func newCookieChanges(u *url.URL, cookies []*http.Cookie, now time.Time, psl PublicSuffixList) ([]cookieChange, error) {
+	host, err := canonicalHost(u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	https := u.Scheme == "https"
+	defPath := defaultPath(u.Path)
+
+	changes := make([]cookieChange, 0, len(cookies))
+	for _, c := range cookies {
+		change, err := newCookieChange(c, now, defPath, host, https, psl)
+		if err != nil {
+			continue
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+func newCookieChange(c *http.Cookie, now time.Time, defPath string, host string, https bool, psl PublicSuffixList) (cookieChange, error) {
+	e := entry{
+		Name:     c.Name,
+		Value:    c.Value,
+		Quoted:   c.Quoted,
+		Secure:   c.Secure,
+		HttpOnly: c.HttpOnly,
+		SameSite: sameSiteString(c.SameSite),
+		Creation: now,
+	}
+	if c.Secure && !https {
+		return cookieChange{}, errInsecureSecure
+	}
+
+	if c.Path == "" || c.Path[0] != '/' {
+		e.Path = defPath
+	} else {
+		e.Path = c.Path
+	}
+
+	var err error
+	e.Domain, e.HostOnly, err = domainAndType(host, c.Domain, psl)
+	if err != nil {
+		return cookieChange{}, err
+	}
+
+	// Max-Age takes precedence over Expires. http.Cookie reports
+	// "Max-Age=0" and negative values as MaxAge < 0.
+	switch {
+	case c.MaxAge < 0:
+		return cookieChange{entry: e, remove: true}, nil
+	case c.MaxAge > 0:
+		e.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		e.Persistent = true
+	case !c.Expires.IsZero():
+		if !c.Expires.After(now) {
+			return cookieChange{entry: e, remove: true}, nil
+		}
+		e.Expires = c.Expires
+		e.Persistent = true
+	}
+	return cookieChange{entry: e}, nil
+}
+
+// domainAndType implements steps 4 to 6 of section 5.3: it returns the
+// domain an entry is stored under and whether it is host-only.
+func domainAndType(host string, domain string, psl PublicSuffixList) (string, bool, error) {
+	if domain == "" {
+		return host, true, nil
+	}
+	if isIP(host) {
+		// An IP address only sets host-only cookies, and only for itself.
+		if host != domain {
+			return "", false, errIllegalDomain
+		}
+		return host, true, nil
+	}
+
+	domain = strings.TrimPrefix(domain, ".")
+	if domain == "" || domain[len(domain)-1] == '.' {
+		return "", false, errMalformedDomain
+	}
+	domain = strings.ToLower(domain)
+
+	// A public suffix is only accepted as the host itself, and then makes a
+	// host-only cookie (step 5).
+	if isPublicSuffix(psl, domain) {
+		if host == domain {
+			return host, true, nil
+		}
+		return "", false, errIllegalDomain
+	}
+
+	if host != domain && !hasDotSuffix(host, domain) {
+		return "", false, errIllegalDomain
+	}
+	return domain, false, nil
+}
+
+// selectCookies returns the cookies of entries to send to u, ordered by
+// longest path first and then by earliest creation (section 5.4 step 2).
+func selectCookies(entries []entry, u *url.URL, now time.Time) []*http.Cookie {
+	host, err := canonicalHost(u.Hostname())
+	if err != nil {
+		return nil
+	}
+	https := u.Scheme == "https"
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+
+	var selected []entry
+	for _, e := range entries {
+		if e.expired(now) || !e.shouldSend(https, host, path) {
+			continue
+		}
+		selected = append(selected, e)
+	}
+	sortEntries(selected)
+
+	if len(selected) == 0 {
+		return nil
+	}
+	cookies := make([]*http.Cookie, len(selected))
+	for i := range selected {
+		cookies[i] = selected[i].cookie()
+	}
+	return cookies
+}
+
+func sortEntries(entries []entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if len(entries[i].Path) != len(entries[j].Path) {
+			return len(entries[i].Path) > len(entries[j].Path)
+		}
+		if !entries[i].Creation.Equal(entries[j].Creation) {
+			return entries[i].Creation.Before(entries[j].Creation)
+		}
+		return entries[i].id() < entries[j].id()
+	})
+}
+
+// jarKey returns the registrable part of host under which every cookie that
+// may be sent to host is stored: the public suffix plus one label. Hosts that
+// are themselves public suffixes are their own key.
+func jarKey(host string, psl PublicSuffixList) string {
+	if isIP(host) {
+		return host
+	}
+	if psl != nil {
+		suffix := psl.PublicSuffix(host)
+		if suffix == host || !hasDotSuffix(host, suffix) {
+			return host
+		}
+		prevDot := strings.LastIndex(host[:len(host)-len(suffix)-1], ".")
+		return host[prevDot+1:]
+	}
+	i := strings.LastIndex(host, ".")
+	if i <= 0 {
+		return host
+	}
+	prevDot := strings.LastIndex(host[:i], ".")
+	return host[prevDot+1:]
+}
+
+// cookieDomains returns host and the parent domains that may hold cookies
+// sent to host. Without a public suffix list every parent is returned.
+func cookieDomains(host string, psl PublicSuffixList) []string {
+	domains := []string{host}
+	if isIP(host) {
+		return domains
+	}
+	last := ""
+	if psl != nil {
+		last = jarKey(host, psl)
+	}
+	for domain := host; domain != last; {
+		i := strings.IndexByte(domain, '.')
+		if i < 0 {
+			break
+		}
+		domain = domain[i+1:]
+		domains = append(domains, domain)
+	}
+	return domains
+}
+
+// canonicalHost lowercases host, strips a trailing dot and converts
+// internationalized names to their ASCII form.
+func canonicalHost(host string) (string, error) {
+	if host == "" {
+		return "", errNoHostname
+	}
+	host = strings.TrimSuffix(host, ".")
+	if isIP(host) {
+		return host, nil
+	}
+	for i := 0; i < len(host); i++ {
+		if host[i] >= 0x80 {
+			ascii, err := idna.ToASCII(host)
+			if err != nil {
+				return "", err
+			}
+			host = ascii
+			break
+		}
+	}
+	return strings.ToLower(host), nil
+}
+
+// defaultPath implements section 5.1.4's default-path algorithm.
+func defaultPath(path string) string {
+	if path == "" || path[0] != '/' {
+		return "/"
+	}
+	i := strings.LastIndex(path, "/")
+	if i == 0 {
+		return "/"
+	}
+	return path[:i]
+}
+
+func isPublicSuffix(psl PublicSuffixList, domain string) bool {
+	return psl != nil && psl.PublicSuffix(domain) == domain
+}
+
+func isIP(host string) bool {
+	return net.ParseIP(host) != nil
+}
+
+func hasDotSuffix(s string, suffix string) bool {
+	return len(s) > len(suffix) && s[len(s)-len(suffix)-1] == '.' && s[len(s)-len(suffix):] == suffix
+}
+
+func sameSiteString(s http.SameSite) string {
+	switch s {
+	case http.SameSiteLaxMode:
+		return "Lax"
+	case http.SameSiteStrictMode:
+		return "Strict"
+	case http.SameSiteNoneMode:
+		return "None"
+	}
+	return ""
+}
+
+func parseSameSite(s string) http.SameSite {
+	switch s {
+	case "Lax":
+		return http.SameSiteLaxMode
+	case "Strict":
+		return http.SameSiteStrictMode
+	case "None":
+		return http.SameSiteNoneMode
+	}
+	return 0
+}
@@ -0,0 +1,480 @@
+package cookiestore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteCookieStore keeps cookies in a table of an SQLite database file,
+// laid out like PostgresCookieStore's. The database runs in WAL mode, so
+// lookups are not blocked by writes, and several processes may share the
+// file.
+type SQLiteCookieStore struct {
+	db        *sql.DB
+	tableName string
+	timeout   time.Duration
+	stmts     sqlStatements
+	// ownsDB is unset for profiles, which share the database and statements
+	// of the store they came from.
+	ownsDB  bool
+	profile string
+	psl     PublicSuffixList
+	onError ErrorHandler
+	logger  *slog.Logger
+}
+
+type SQLiteCookieStoreOption struct {
+	// TableName is the table holding the cookies. It defaults to "cookies".
+	TableName string
+	// BusyTimeout is how long a write waits for another connection or
+	// process writing to the database. It defaults to 5 seconds.
+	BusyTimeout time.Duration
+	// StatementTimeout bounds the queries of each call, including setting up
+	// the table. It defaults to 5 seconds.
+	StatementTimeout time.Duration
+}
+
+// The table holds one row per cookie and profile. Times are Unix
+// microseconds; expires is NULL for session cookies.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS %[1]s (
+	profile TEXT NOT NULL DEFAULT '',
+	domain TEXT NOT NULL,
+	path TEXT NOT NULL,
+	name TEXT NOT NULL,
+	value TEXT NOT NULL,
+	quoted INTEGER NOT NULL DEFAULT 0,
+	expires INTEGER,
+	secure INTEGER NOT NULL DEFAULT 0,
+	httponly INTEGER NOT NULL DEFAULT 0,
+	samesite TEXT NOT NULL DEFAULT '',
+	host_only INTEGER NOT NULL DEFAULT 0,
+	created_at INTEGER NOT NULL,
+	PRIMARY KEY (profile, domain, path, name)
+);
+CREATE INDEX IF NOT EXISTS %[2]s ON %[1]s (expires) WHERE expires IS NOT NULL;`
+
+const defaultSQLiteTableName = "cookies"
+
+var sqliteIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// NewSQLiteCookieStore opens the database at path, creating it and the table
+// if needed. path may also be a "file:" URI or ":memory:" for a database
+// that lives as long as the store. Close closes the database.
+func NewSQLiteCookieStore(path string, option *SQLiteCookieStoreOption) (*SQLiteCookieStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("database path cannot be empty")
+	}
+	if option == nil {
+		option = &SQLiteCookieStoreOption{}
+	}
+	tableName := option.TableName
+	if tableName == "" {
+		tableName = defaultSQLiteTableName
+	}
+	if !sqliteIdentifier.MatchString(tableName) {
+		return nil, fmt.Errorf("invalid table name %q", tableName)
+	}
+	busyTimeout := option.BusyTimeout
+	if busyTimeout <= 0 {
+		busyTimeout = defaultStatementTimeout
+	}
+
+	// Transactions take the write lock when they begin, so that concurrent
+	// ones wait for the busy timeout instead of failing when they upgrade.
+	query := url.Values{
+		"_pragma": {
+			"busy_timeout(" + strconv.FormatInt(busyTimeout.Milliseconds(), 10) + ")",
+			"journal_mode(WAL)",
+			"synchronous(NORMAL)",
+		},
+		"_txlock": {"immediate"},
+	}
+	dsn, memory, err := sqliteDSN(path, query)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %s: %w", path, err)
+	}
+	if memory {
+		// Every connection to an in-memory database opens a new one.
+		db.SetMaxOpenConns(1)
+	}
+
+	s := &SQLiteCookieStore{
+		db:        db,
+		tableName: `"` + tableName + `"`,
+		timeout:   option.StatementTimeout,
+		ownsDB:    true,
+		psl:       DefaultPublicSuffixList,
+	}
+	if s.timeout <= 0 {
+		s.timeout = defaultStatementTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(sqliteSchema, s.tableName, `"`+tableName+`_expires_idx"`)); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create table %s: %w", s.tableName, err)
+	}
+	if err := s.prepare(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prepare statements for table %s: %w", s.tableName, err)
+	}
+	return s, nil
+}
+
+// sqliteDSN returns the "file:" URI for path with the driver options in
+// query, and whether it names an in-memory database.
+func sqliteDSN(path string, query url.Values) (string, bool, error) {
+	u := &url.URL{Scheme: "file"}
+	switch {
+	case path == ":memory:":
+		u.Opaque = path
+	case strings.HasPrefix(path, "file:"):
+		parsed, err := url.Parse(path)
+		if err != nil {
+			return "", false, fmt.Errorf("invalid database URI %q: %w", path, err)
+		}
+		u = parsed
+		for key, values := range u.Query() {
+			if _, ok := query[key]; !ok {
+				query[key] = values
+			}
+		}
+	default:
+		// SQLite decodes the path of URIs, so ? and # in file names are
+		// escaped.
+		u.Opaque = (&url.URL{Path: filepath.ToSlash(path)}).EscapedPath()
+	}
+	u.RawQuery = query.Encode()
+	memory := strings.HasPrefix(u.Opaque, ":memory:") || query.Get("mode") == "memory"
+	return u.String(), memory, nil
+}
+
+// WithPublicSuffixList replaces DefaultPublicSuffixList. A nil list turns
+// the public suffix check off.
+func (s *SQLiteCookieStore) WithPublicSuffixList(psl PublicSuffixList) *SQLiteCookieStore {
+	s.psl = psl
+	return s
+}
+
+// WithErrorHandler sets the handler for errors of SetCookies and Cookies,
+// which cannot return them. By default they are logged to the store's
+// logger.
+func (s *SQLiteCookieStore) WithErrorHandler(onError ErrorHandler) *SQLiteCookieStore {
+	s.onError = onError
+	return s
+}
+
+// WithLogger sets the logger for store events and, unless WithErrorHandler
+// was used, errors. Events are logged at debug level with hosts and cookie
+// counts but never cookie values. It defaults to slog.Default.
+func (s *SQLiteCookieStore) WithLogger(logger *slog.Logger) *SQLiteCookieStore {
+	s.logger = logger
+	return s
+}
+
+func (s *SQLiteCookieStore) WithContext(ctx context.Context) http.CookieJar {
+	return s.jar().WithContext(ctx)
+}
+
+func (s *SQLiteCookieStore) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	s.jar().SetCookies(u, cookies)
+}
+
+func (s *SQLiteCookieStore) Cookies(u *url.URL) []*http.Cookie {
+	return s.jar().Cookies(u)
+}
+
+func (s *SQLiteCookieStore) jar() ContextJar {
+	return NewCookieJar(s, errorHandler(s.onError, s.logger))
+}
+
+func (s *SQLiteCookieStore) log() *slog.Logger {
+	return loggerOrDefault(s.logger)
+}
+
+// SetCookiesContext stores cookies in one transaction. Each cookie replaces
+// the row with its name, domain and path, keeping its creation time, or
+// deletes it when it has expired.
+func (s *SQLiteCookieStore) SetCookiesContext(ctx context.Context, u *url.URL, cookies []*http.Cookie) error {
+	changes, err := newCookieChanges(u, cookies, time.Now(), s.psl)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if len(changes) == 0 {
+		s.log().DebugContext(ctx, "cookies rejected", "host", host, "rejected", len(cookies))
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	upsert := tx.StmtContext(ctx, s.stmts.upsert)
+	deleteOne := tx.StmtContext(ctx, s.stmts.deleteOne)
+	for _, change := range changes {
+		e := change.entry
+		if change.remove {
+			_, err = deleteOne.ExecContext(ctx, s.profile, e.Domain, e.Path, e.Name)
+		} else {
+			var expires sql.NullInt64
+			if e.Persistent {
+				expires = sql.NullInt64{Int64: e.Expires.UnixMicro(), Valid: true}
+			}
+			_, err = upsert.ExecContext(ctx, s.profile, e.Domain, e.Path, e.Name, e.Value, e.Quoted, expires,
+				e.Secure, e.HttpOnly, e.SameSite, e.HostOnly, e.Creation.UnixMicro())
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save cookies for host %s: %w", host, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to save cookies for host %s: %w", host, err)
+	}
+	logChanges(ctx, s.log(), host, len(cookies), changes)
+	return nil
+}
+
+func (s *SQLiteCookieStore) CookiesContext(ctx context.Context, u *url.URL) ([]*http.Cookie, error) {
+	host, err := canonicalHost(u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	domains, err := json.Marshal(cookieDomains(host, s.psl))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	now := time.Now()
+	entries, err := s.query(ctx, s.stmts.selectDomains, s.profile, string(domains), now.UnixMicro())
+	if err != nil {
+		return nil, err
+	}
+	cookies := selectCookies(entries, u, now)
+	s.log().DebugContext(ctx, "cookies loaded", "host", host, "count", len(cookies))
+	return cookies, nil
+}
+
+func (s *SQLiteCookieStore) AllCookies() ([]*http.Cookie, error) {
+	entries, err := s.entries(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	cookies := make([]*http.Cookie, len(entries))
+	for i, e := range entries {
+		cookies[i] = exportCookie(e)
+	}
+	sortCookies(cookies)
+	return cookies, nil
+}
+
+func (s *SQLiteCookieStore) Hosts() ([]string, error) {
+	entries, err := s.entries(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return entryHosts(entries), nil
+}
+
+func (s *SQLiteCookieStore) DeleteCookie(host string, name string, path string) error {
+	host, err := canonicalHost(host)
+	if err != nil {
+		return err
+	}
+	return s.exec(context.Background(), s.stmts.deleteCookie, s.profile, host, name, path)
+}
+
+func (s *SQLiteCookieStore) ClearHost(host string) error {
+	host, err := canonicalHost(host)
+	if err != nil {
+		return err
+	}
+	return s.exec(context.Background(), s.stmts.clearHost, s.profile, host)
+}
+
+func (s *SQLiteCookieStore) ClearAll() error {
+	return s.exec(context.Background(), s.stmts.clearAll, s.profile)
+}
+
+func (s *SQLiteCookieStore) PurgeExpired() error {
+	return s.exec(context.Background(), s.stmts.purgeExpired, s.profile, time.Now().UnixMicro())
+}
+
+// Profile returns a view of the store acting on the named profile, which
+// shares the store's database and statements.
+func (s *SQLiteCookieStore) Profile(name string) Store {
+	return s.withProfile(name)
+}
+
+func (s *SQLiteCookieStore) Profiles() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	rows, err := s.stmts.profiles.QueryContext(ctx, time.Now().UnixMicro())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list profiles: %w", err)
+	}
+	defer rows.Close()
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan profile row: %w", err)
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate profile rows: %w", err)
+	}
+	return names, nil
+}
+
+// CloneProfile replaces the cookies of dst with those of src in one
+// transaction.
+func (s *SQLiteCookieStore) CloneProfile(src string, dst string) error {
+	if src == dst {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.StmtContext(ctx, s.stmts.clearAll).ExecContext(ctx, dst); err != nil {
+		return fmt.Errorf("failed to clone profile %q: %w", src, err)
+	}
+	if _, err := tx.StmtContext(ctx, s.stmts.clone).ExecContext(ctx, src, dst, time.Now().UnixMicro()); err != nil {
+		return fmt.Errorf("failed to clone profile %q: %w", src, err)
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteCookieStore) DeleteProfile(name string) error {
+	return s.withProfile(name).ClearAll()
+}
+
+func (s *SQLiteCookieStore) withProfile(name string) *SQLiteCookieStore {
+	view := *s
+	view.profile = name
+	view.ownsDB = false
+	return &view
+}
+
+// Close releases the store's prepared statements and closes the database.
+// Closing a profile does nothing.
+func (s *SQLiteCookieStore) Close() error {
+	if !s.ownsDB {
+		return nil
+	}
+	err := s.stmts.close()
+	if dbErr := s.db.Close(); err == nil {
+		err = dbErr
+	}
+	return err
+}
+
+func (s *SQLiteCookieStore) exec(ctx context.Context, stmt *sql.Stmt, args ...any) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if _, err := stmt.ExecContext(ctx, args...); err != nil {
+		return fmt.Errorf("failed to delete cookies: %w", err)
+	}
+	return nil
+}
+
+// entries returns every unexpired cookie of the profile.
+func (s *SQLiteCookieStore) entries(ctx context.Context) ([]entry, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	return s.query(ctx, s.stmts.selectAll, s.profile, time.Now().UnixMicro())
+}
+
+func (s *SQLiteCookieStore) query(ctx context.Context, stmt *sql.Stmt, args ...any) ([]entry, error) {
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve cookies: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		var expires sql.NullInt64
+		var creation int64
+		err := rows.Scan(&e.Domain, &e.Path, &e.Name, &e.Value, &e.Quoted, &expires,
+			&e.Secure, &e.HttpOnly, &e.SameSite, &e.HostOnly, &creation)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan cookie row: %w", err)
+		}
+		e.Persistent = expires.Valid
+		if expires.Valid {
+			e.Expires = time.UnixMicro(expires.Int64)
+		}
+		e.Creation = time.UnixMicro(creation)
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate cookie rows: %w", err)
+	}
+	return entries, nil
+}
+
+func (s *SQLiteCookieStore) prepare(ctx context.Context) error {
+	queries := []string{
+		fmt.Sprintf("SELECT %s FROM %s WHERE profile = ?1 AND domain IN (SELECT value FROM json_each(?2)) AND (expires IS NULL OR expires > ?3);", sqlColumns, s.tableName),
+		fmt.Sprintf("SELECT %s FROM %s WHERE profile = ?1 AND (expires IS NULL OR expires > ?2);", sqlColumns, s.tableName),
+		fmt.Sprintf(`
+		INSERT INTO %s (profile, %s) VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11, ?12)
+		ON CONFLICT (profile, domain, path, name) DO UPDATE SET
+			value = excluded.value, quoted = excluded.quoted, expires = excluded.expires,
+			secure = excluded.secure, httponly = excluded.httponly, samesite = excluded.samesite,
+			host_only = excluded.host_only;`, s.tableName, sqlColumns),
+		fmt.Sprintf("DELETE FROM %s WHERE profile = ?1 AND domain = ?2 AND path = ?3 AND name = ?4;", s.tableName),
+		fmt.Sprintf("DELETE FROM %s WHERE profile = ?1 AND domain = ?2 AND name = ?3 AND (?4 = '' OR path = ?4);", s.tableName),
+		fmt.Sprintf("DELETE FROM %s WHERE profile = ?1 AND (domain = ?2 OR substr(domain, -length(?2) - 1) = '.' || ?2);", s.tableName),
+		fmt.Sprintf("DELETE FROM %s WHERE profile = ?1;", s.tableName),
+		fmt.Sprintf("DELETE FROM %s WHERE profile = ?1 AND expires <= ?2;", s.tableName),
+		fmt.Sprintf("SELECT DISTINCT profile FROM %s WHERE profile <> '' AND (expires IS NULL OR expires > ?1) ORDER BY profile;", s.tableName),
+		fmt.Sprintf("INSERT INTO %[1]s (profile, %[2]s) SELECT ?2, %[2]s FROM %[1]s WHERE profile = ?1 AND (expires IS NULL OR expires > ?3);", s.tableName, sqlColumns),
+	}
+	for i, stmt := range s.stmts.all() {
+		var err error
+		if *stmt, err = s.db.PrepareContext(ctx, queries[i]); err != nil {
+			s.stmts.close()
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package cookiestore_test
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/takumi3488/twocker/cookiestore"
+)
+
+func newSQLiteCookieStore(t *testing.T, path string, option *cookiestore.SQLiteCookieStoreOption) *cookiestore.SQLiteCookieStore {
+	t.Helper()
+	store, err := cookiestore.NewSQLiteCookieStore(path, option)
+	require.NoError(t, err)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestSQLiteCookieStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.db")
+	store := newSQLiteCookieStore(t, path, nil)
+
+	t.Run("SetAndGetCookies_Basic", func(t *testing.T) {
+		testURL, _ := url.Parse("https://sub.example.com/some/path?query=1")
+		cookiesToSet := []*http.Cookie{
+			{Name: "session-id", Value: "abc123xyz", Path: "/", Domain: "example.com", HttpOnly: true},
+			{Name: "user_preference", Value: "theme=dark&lang=en", Path: "/some", Domain: "sub.example.com"},
+		}
+
+		store.SetCookies(testURL, cookiesToSet)
+
+		retrievedCookies := store.Cookies(testURL)
+		require.NotNil(t, retrievedCookies, "Retrieved cookies should not be nil for host sub.example.com")
+		compareCookieSlices(t, cookiesToSet, retrievedCookies)
+
+		// Only the domain cookie is sent to the parent domain
+		baseDomainURL, _ := url.Parse("https://example.com/")
+		compareCookieSlices(t, cookiesToSet[:1], store.Cookies(baseDomainURL))
+	})
+
+	t.Run("GetCookies_NotFound", func(t *testing.T) {
+		testURL, _ := url.Parse("https://another-domain.org")
+		require.Nil(t, store.Cookies(testURL), "Cookies for a host not previously set should be nil")
+	})
+
+	t.Run("SetCookies_Merge", func(t *testing.T) {
+		testURL, _ := url.Parse("https://sub.example.com/some/another?q=2")
+		require.NotNil(t, store.Cookies(testURL), "Should have cookies before merge")
+
+		newCookies := []*http.Cookie{
+			{Name: "session-id", Value: "new-session-value-456", Path: "/", Domain: "example.com"}, // Overwrite
+			{Name: "tracker-status", Value: "opt-out", Path: "/", Domain: "sub.example.com"},       // New cookie
+		}
+
+		store.SetCookies(testURL, newCookies)
+		// The 'user_preference' cookie set earlier is kept
+		compareCookieSlices(t, append(newCookies, &http.Cookie{
+			Name: "user_preference", Value: "theme=dark&lang=en", Path: "/some", Domain: "sub.example.com",
+		}), store.Cookies(testURL))
+	})
+
+	t.Run("SetCookies_EmptyURLHostname", func(t *testing.T) {
+		invalidURL := &url.URL{Scheme: "http", Path: "/no-host"}
+		store.SetCookies(invalidURL, []*http.Cookie{{Name: "should-not-be-set", Value: "value"}})
+		validURL, _ := url.Parse("https://sub.example.com")
+		require.Len(t, store.Cookies(validURL), 2, "Should still have 2 cookies for sub.example.com after invalid set attempt")
+	})
+
+	t.Run("Cookies_EmptyURLHostname", func(t *testing.T) {
+		invalidURL := &url.URL{Scheme: "http", Path: "/no-host-retrieve"}
+		require.Nil(t, store.Cookies(invalidURL), "Retrieving cookies for URL with empty hostname should return nil")
+	})
+
+	t.Run("Persistence", func(t *testing.T) {
+		u, _ := url.Parse("https://sub.example.com/some/")
+		reopened := newSQLiteCookieStore(t, path, nil)
+		require.Len(t, reopened.Cookies(u), 3, "Cookies should be read from the file")
+	})
+
+	t.Run("ImportAndExport", func(t *testing.T) {
+		runImportExportTest(t, store)
+	})
+
+	t.Run("StoreManagement", func(t *testing.T) {
+		runStoreManagementTest(t, store)
+	})
+
+	t.Run("Profiles", func(t *testing.T) {
+		runProfileTest(t, store)
+	})
+}
+
+func TestSQLiteCookieStoreConformance(t *testing.T) {
+	dir := t.TempDir()
+	n := 0
+	runConformanceTests(t, func(t *testing.T) http.CookieJar {
+		n++
+		return newSQLiteCookieStore(t, filepath.Join(dir, "cookies.db"), &cookiestore.SQLiteCookieStoreOption{
+			TableName: "conformance_" + strconv.Itoa(n),
+		})
+	})
+}
+
+func TestSQLiteCookieStoreConcurrentStores(t *testing.T) {
+	// Two stores on one file behave like two processes sharing it.
+	path := filepath.Join(t.TempDir(), "cookies.db")
+	stores := []*cookiestore.SQLiteCookieStore{
+		newSQLiteCookieStore(t, path, nil),
+		newSQLiteCookieStore(t, path, nil),
+	}
+	u, _ := url.Parse("https://www.example.com/")
+
+	var wg sync.WaitGroup
+	errs := make([]error, 8)
+	for g := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20 && errs[g] == nil; i++ {
+				errs[g] = stores[g%2].SetCookiesContext(context.Background(), u, []*http.Cookie{
+					{Name: "n" + strconv.Itoa(g), Value: strconv.Itoa(i)},
+					{Name: "shared", Value: strconv.Itoa(i)},
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+
+	cookies := stores[0].Cookies(u)
+	require.Len(t, cookies, 9, "Concurrent responses should not lose cookies")
+	for _, c := range cookies {
+		if c.Name != "shared" {
+			require.Equal(t, "19", c.Value, "Last value of %s", c.Name)
+		}
+	}
+}
+
+func TestSQLiteCookieStoreWAL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.db")
+	store := newSQLiteCookieStore(t, path, &cookiestore.SQLiteCookieStoreOption{TableName: "jar"})
+	u, _ := url.Parse("https://www.example.com/")
+	store.SetCookies(u, []*http.Cookie{{Name: "a", Value: "1"}})
+
+	// The file can be queried with any SQLite client.
+	db, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer db.Close()
+	var mode string
+	require.NoError(t, db.QueryRow("PRAGMA journal_mode;").Scan(&mode))
+	require.Equal(t, "wal", mode)
+	var value string
+	require.NoError(t, db.QueryRow("SELECT value FROM jar WHERE domain = 'www.example.com' AND name = 'a';").Scan(&value))
+	require.Equal(t, "1", value)
+}
+
+func TestSQLiteCookieStoreInvalidTableName(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.db")
+	for _, name := range []string{"cookies; DROP TABLE users", `"cookies"`, "main.cookies"} {
+		_, err := cookiestore.NewSQLiteCookieStore(path, &cookiestore.SQLiteCookieStoreOption{TableName: name})
+		require.Error(t, err, "Table name %q should be rejected", name)
+	}
+}
+
+func TestSQLiteCookieStorePaths(t *testing.T) {
+	dir := t.TempDir()
+	u, _ := url.Parse("https://www.example.com/")
+	for _, path := range []string{
+		filepath.Join(dir, "what?.db"),
+		filepath.Join(dir, "hash#.db"),
+		"file:" + filepath.ToSlash(filepath.Join(dir, "uri.db")) + "?cache=private",
+		":memory:",
+	} {
+		store := newSQLiteCookieStore(t, path, nil)
+		store.SetCookies(u, []*http.Cookie{{Name: "a", Value: "1"}})
+		require.Equal(t, "a=1", cookieHeader(store.Cookies(u)), "Path %q", path)
+		runProfileTest(t, store)
+	}
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), "-wal") && !strings.HasSuffix(e.Name(), "-shm") {
+			names = append(names, e.Name())
+		}
+	}
+	require.ElementsMatch(t, []string{"what?.db", "hash#.db", "uri.db"}, names)
+}
@@ -0,0 +1,143 @@
+package cookiestore
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Store is a cookie jar whose contents can be listed and managed. Hosts are
+// cookie domains without a leading dot; a host-only cookie belongs to the
+// host that set it.
+type Store interface {
+	http.CookieJar
+	// AllCookies returns every unexpired cookie in the form read by
+	// ImportCookies.
+	AllCookies() ([]*http.Cookie, error)
+	// Hosts returns the sorted domains that have unexpired cookies.
+	Hosts() ([]string, error)
+	// DeleteCookie removes the cookie name stored for host with path, or
+	// with any path when path is empty.
+	DeleteCookie(host string, name string, path string) error
+	// ClearHost removes the cookies stored for host and its subdomains.
+	ClearHost(host string) error
+	ClearAll() error
+	// PurgeExpired removes expired cookies, which are otherwise only
+	// skipped when cookies are read.
+	PurgeExpired() error
+}
+
+// DefaultProfile names the profile of a store itself.
+const DefaultProfile = ""
+
+// ProfileStore keeps isolated sets of cookies, profiles, in one backend, for
+// example one per account scraping the same sites. The store itself is
+// DefaultProfile.
+type ProfileStore interface {
+	Store
+	// Profile returns the jar of the named profile. Its cookies are never
+	// sent to the other profiles.
+	Profile(name string) Store
+	// Profiles returns the sorted names of the profiles other than
+	// DefaultProfile that have cookies.
+	Profiles() ([]string, error)
+	// CloneProfile replaces the cookies of dst with a copy of those of src.
+	CloneProfile(src string, dst string) error
+	// DeleteProfile removes every cookie of the profile.
+	DeleteProfile(name string) error
+}
+
+var (
+	_ Store = (*InMemoryCookieStore)(nil)
+	_ Store = (*FileCookieStore)(nil)
+	_ Store = (*RedisCookieStore)(nil)
+	_ Store = (*PostgresCookieStore)(nil)
+	_ Store = (*SQLiteCookieStore)(nil)
+
+	_ ErrCookieStore = (*InMemoryCookieStore)(nil)
+	_ ErrCookieStore = (*FileCookieStore)(nil)
+	_ ErrCookieStore = (*RedisCookieStore)(nil)
+	_ ErrCookieStore = (*PostgresCookieStore)(nil)
+	_ ErrCookieStore = (*SQLiteCookieStore)(nil)
+
+	_ ProfileStore = (*InMemoryCookieStore)(nil)
+	_ ProfileStore = (*FileCookieStore)(nil)
+	_ ProfileStore = (*RedisCookieStore)(nil)
+	_ ProfileStore = (*PostgresCookieStore)(nil)
+	_ ProfileStore = (*SQLiteCookieStore)(nil)
+)
+
+// entryFilter selects the entries removed by a management operation.
+type entryFilter func(e entry) bool
+
+func deleteCookieFilter(host string, name string, path string) (entryFilter, error) {
+	host, err := canonicalHost(host)
+	if err != nil {
+		return nil, err
+	}
+	return func(e entry) bool {
+		return e.Domain == host && e.Name == name && (path == "" || e.Path == path)
+	}, nil
+}
+
+func clearHostFilter(host string) (entryFilter, error) {
+	host, err := canonicalHost(host)
+	if err != nil {
+		return nil, err
+	}
+	return func(e entry) bool {
+		return e.Domain == host || hasDotSuffix(e.Domain, host)
+	}, nil
+}
+
+func expiredFilter(now time.Time) entryFilter {
+	return func(e entry) bool {
+		return e.expired(now)
+	}
+}
+
+// storedEntry converts a cookie kept as received from host, as earlier
+// versions of the Redis and Postgres stores did, to an entry.
+func storedEntry(host string, c *http.Cookie) entry {
+	e := entry{
+		Name:     c.Name,
+		Value:    c.Value,
+		Quoted:   c.Quoted,
+		Domain:   strings.ToLower(strings.TrimPrefix(c.Domain, ".")),
+		Path:     c.Path,
+		SameSite: sameSiteString(c.SameSite),
+		Secure:   c.Secure,
+		HttpOnly: c.HttpOnly,
+	}
+	if e.Domain == "" {
+		e.Domain = host
+		e.HostOnly = true
+	}
+	if e.Path == "" {
+		e.Path = "/"
+	}
+	switch {
+	case c.MaxAge < 0:
+		// Already expired: the zero Expires is never after now.
+		e.Persistent = true
+	case !c.Expires.IsZero():
+		e.Persistent = true
+		e.Expires = c.Expires
+	}
+	return e
+}
+
+// entryHosts returns the sorted distinct domains of entries.
+func entryHosts(entries []entry) []string {
+	seen := make(map[string]bool)
+	hosts := []string{}
+	for _, e := range entries {
+		if !seen[e.Domain] {
+			seen[e.Domain] = true
+			hosts = append(hosts, e.Domain)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
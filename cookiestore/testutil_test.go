@@ -2,9 +2,14 @@ package cookiestore_test
 
 import (
 	"net/http"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/takumi3488/twocker/cookiestore"
 )
 
 // compareCookieSlices compares two slices of cookies. Order doesn't matter.
@@ -41,3 +46,363 @@ func compareCookieSlices(t *testing.T, expected, actual []*http.Cookie) {
 		require.Equal(t, expCookie.HttpOnly, actCookie.HttpOnly, "HttpOnly mismatch for cookie '%s'", name)
 	}
 }
+
+// conformanceQuery is a request made after a conformance test has set its
+// cookies, with the expected Cookie header as "name=value" pairs in order.
+type conformanceQuery struct {
+	toURL string
+	want  string
+}
+
+type conformanceTest struct {
+	description string
+	fromURL     string
+	setCookies  []string
+	queries     []conformanceQuery
+}
+
+// conformanceTests exercise the RFC 6265 storage and retrieval model.
+// Each test runs against a fresh jar.
+var conformanceTests = []conformanceTest{
+	{
+		"Host-only cookie.",
+		"http://www.host.test",
+		[]string{"a=1"},
+		[]conformanceQuery{
+			{"http://www.host.test", "a=1"},
+			{"http://WWW.Host.Test:8080/path", "a=1"},
+			{"http://sub.www.host.test", ""},
+			{"http://host.test", ""},
+			{"http://other.test", ""},
+		},
+	},
+	{
+		"Domain cookie.",
+		"http://www.host.test",
+		[]string{"a=1; domain=host.test"},
+		[]conformanceQuery{
+			{"http://host.test", "a=1"},
+			{"http://www.host.test", "a=1"},
+			{"http://sub.www.host.test", "a=1"},
+			{"http://otherhost.test", ""},
+		},
+	},
+	{
+		"Domain cookie with leading dot and uppercase.",
+		"http://www.host.test",
+		[]string{"a=1; domain=.HOST.test"},
+		[]conformanceQuery{
+			{"http://host.test", "a=1"},
+			{"http://foo.host.test", "a=1"},
+		},
+	},
+	{
+		"Domain cookie for the host itself is not host-only.",
+		"http://www.host.test",
+		[]string{"a=1; domain=www.host.test"},
+		[]conformanceQuery{
+			{"http://www.host.test", "a=1"},
+			{"http://sub.www.host.test", "a=1"},
+			{"http://host.test", ""},
+		},
+	},
+	{
+		"Illegal domains are rejected.",
+		"http://www.host.test",
+		[]string{"a=1; domain=other.test", "b=2; domain=sub.www.host.test", "c=3; domain=ost.test", "d=4; domain=host.test."},
+		[]conformanceQuery{
+			{"http://www.host.test", ""},
+			{"http://other.test", ""},
+			{"http://sub.www.host.test", ""},
+		},
+	},
+	{
+		"Default path.",
+		"http://www.host.test/foo/bar",
+		[]string{"a=1"},
+		[]conformanceQuery{
+			{"http://www.host.test/foo", "a=1"},
+			{"http://www.host.test/foo/", "a=1"},
+			{"http://www.host.test/foo/baz", "a=1"},
+			{"http://www.host.test/foobar", ""},
+			{"http://www.host.test/", ""},
+		},
+	},
+	{
+		"Explicit path.",
+		"http://www.host.test/",
+		[]string{"a=1; path=/some", "b=2; path=/dir/"},
+		[]conformanceQuery{
+			{"http://www.host.test/some", "a=1"},
+			{"http://www.host.test/some/path", "a=1"},
+			{"http://www.host.test/someother", ""},
+			{"http://www.host.test/dir/file", "b=2"},
+			{"http://www.host.test/dir", ""},
+		},
+	},
+	{
+		"Secure cookies are only sent over https.",
+		"https://www.host.test",
+		[]string{"a=1; secure", "b=2"},
+		[]conformanceQuery{
+			{"https://www.host.test", "a=1 b=2"},
+			{"http://www.host.test", "b=2"},
+		},
+	},
+	{
+		"Secure cookies cannot be set over http.",
+		"http://www.host.test",
+		[]string{"a=1; secure", "b=2"},
+		[]conformanceQuery{
+			{"https://www.host.test", "b=2"},
+		},
+	},
+	{
+		"Expired and Max-Age=0 cookies are not stored.",
+		"http://www.host.test",
+		[]string{"a=1; max-age=0", "b=2; expires=Thu, 01 Jan 1970 00:00:00 GMT", "c=3; max-age=3600", "d=4; expires=Fri, 01 Jan 2100 00:00:00 GMT"},
+		[]conformanceQuery{
+			{"http://www.host.test", "c=3 d=4"},
+		},
+	},
+	{
+		"Max-Age=0 and past Expires delete cookies.",
+		"http://www.host.test",
+		[]string{"a=1", "b=2", "c=3; path=/p", "a=; max-age=0", "b=; expires=Thu, 01 Jan 1970 00:00:00 GMT", "c=; max-age=-1"},
+		[]conformanceQuery{
+			{"http://www.host.test", ""},
+			{"http://www.host.test/p", "c=3"},
+		},
+	},
+	{
+		"Max-Age takes precedence over Expires.",
+		"http://www.host.test",
+		[]string{"a=1; max-age=3600; expires=Thu, 01 Jan 1970 00:00:00 GMT", "b=2; max-age=0; expires=Fri, 01 Jan 2100 00:00:00 GMT"},
+		[]conformanceQuery{
+			{"http://www.host.test", "a=1"},
+		},
+	},
+	{
+		"A cookie with the same name, domain and path is replaced.",
+		"http://www.host.test",
+		[]string{"a=1", "b=2", "a=3"},
+		[]conformanceQuery{
+			{"http://www.host.test", "a=3 b=2"},
+		},
+	},
+	{
+		"Same name with different paths or domains coexist.",
+		"http://www.host.test/",
+		[]string{"a=1; path=/", "a=2; path=/foo", "a=3; domain=host.test"},
+		[]conformanceQuery{
+			{"http://www.host.test/foo/bar", "a=2 a=1 a=3"},
+			{"http://www.host.test/", "a=1 a=3"},
+			{"http://host.test/", "a=3"},
+		},
+	},
+	{
+		"Longer paths first, then creation order.",
+		"http://www.host.test/",
+		[]string{"b=1", "a=1", "c=1; path=/x", "d=1; path=/x/y"},
+		[]conformanceQuery{
+			{"http://www.host.test/x/y/z", "d=1 c=1 b=1 a=1"},
+		},
+	},
+	{
+		"IP addresses only get host-only cookies.",
+		"http://127.0.0.1",
+		[]string{"a=1", "b=2; domain=127.0.0.1", "c=3; domain=0.0.1"},
+		[]conformanceQuery{
+			{"http://127.0.0.1", "a=1 b=2"},
+			{"http://127.0.0.2", ""},
+		},
+	},
+	{
+		"Hosts without dots.",
+		"http://localhost",
+		[]string{"a=1", "b=2; domain=localhost"},
+		[]conformanceQuery{
+			{"http://localhost", "a=1 b=2"},
+			{"http://other", ""},
+		},
+	},
+	{
+		"Public suffixes are rejected as cookie domains.",
+		"http://www.bbc.co.uk",
+		[]string{"a=1; domain=co.uk", "b=2; domain=.uk", "c=3; domain=bbc.co.uk"},
+		[]conformanceQuery{
+			{"http://www.bbc.co.uk", "c=3"},
+			{"http://other.co.uk", ""},
+		},
+	},
+	{
+		"Private public suffixes are rejected as cookie domains.",
+		"http://foo.github.io",
+		[]string{"a=1; domain=github.io", "b=2; domain=foo.github.io"},
+		[]conformanceQuery{
+			{"http://foo.github.io", "b=2"},
+			{"http://bar.github.io", ""},
+		},
+	},
+	{
+		"A public suffix host only sets host-only cookies for itself.",
+		"http://co.uk",
+		[]string{"a=1; domain=co.uk"},
+		[]conformanceQuery{
+			{"http://co.uk", "a=1"},
+			{"http://bbc.co.uk", ""},
+		},
+	},
+}
+
+// runConformanceTests runs conformanceTests against jars created by
+// newJar, which must return an empty jar on every call.
+func runConformanceTests(t *testing.T, newJar func(t *testing.T) http.CookieJar) {
+	t.Helper()
+	for _, test := range conformanceTests {
+		t.Run(test.description, func(t *testing.T) {
+			jar := newJar(t)
+			fromURL, err := url.Parse(test.fromURL)
+			require.NoError(t, err)
+			for _, setCookie := range test.setCookies {
+				resp := &http.Response{Header: http.Header{"Set-Cookie": {setCookie}}}
+				jar.SetCookies(fromURL, resp.Cookies())
+			}
+			for _, query := range test.queries {
+				toURL, err := url.Parse(query.toURL)
+				require.NoError(t, err)
+				require.Equal(t, query.want, cookieHeader(jar.Cookies(toURL)), "Cookies for %s", query.toURL)
+			}
+		})
+	}
+}
+
+func cookieHeader(cookies []*http.Cookie) string {
+	pairs := make([]string, len(cookies))
+	for i, c := range cookies {
+		pairs[i] = c.Name + "=" + c.Value
+	}
+	return strings.Join(pairs, " ")
+}
+
+// runImportExportTest imports a cookies.txt line into store and checks that
+// it is sent and exported again.
+func runImportExportTest(t *testing.T, store cookiestore.Store) {
+	t.Helper()
+	imported, err := cookiestore.ReadNetscapeCookies(strings.NewReader(".import.test\tTRUE\t/\tFALSE\t0\tsid\t42\n"))
+	require.NoError(t, err)
+	require.NoError(t, cookiestore.ImportCookies(store, imported))
+
+	importURL, _ := url.Parse("https://import.test/")
+	require.Equal(t, "sid=42", cookieHeader(store.Cookies(importURL)))
+
+	all, err := store.AllCookies()
+	require.NoError(t, err)
+	found := false
+	for _, c := range all {
+		if c.Name == "sid" && c.Value == "42" && c.Domain == ".import.test" {
+			found = true
+		}
+	}
+	require.True(t, found, "Imported cookie should be exported as a domain cookie")
+}
+
+// runStoreManagementTest exercises the Store methods. It clears store first.
+func runStoreManagementTest(t *testing.T, store cookiestore.Store) {
+	t.Helper()
+	require.NoError(t, store.ClearAll())
+
+	wwwURL, _ := url.Parse("https://www.manage.test/")
+	subURL, _ := url.Parse("https://www.manage.test/sub")
+	otherURL, _ := url.Parse("https://other.test/")
+	store.SetCookies(wwwURL, []*http.Cookie{
+		{Name: "a", Value: "1", Path: "/"},
+		{Name: "a", Value: "2", Path: "/sub"},
+		{Name: "b", Value: "3", Domain: "manage.test", Path: "/"},
+	})
+	store.SetCookies(otherURL, []*http.Cookie{
+		{Name: "c", Value: "4", Path: "/"},
+		{Name: "expired", Value: "5", Path: "/", Expires: time.Now().Add(-time.Hour)},
+	})
+
+	hosts, err := store.Hosts()
+	require.NoError(t, err)
+	require.Equal(t, []string{"manage.test", "other.test", "www.manage.test"}, hosts)
+
+	require.NoError(t, store.DeleteCookie("WWW.manage.test", "a", "/sub"))
+	require.ElementsMatch(t, []string{"a=1", "b=3"}, strings.Fields(cookieHeader(store.Cookies(subURL))))
+
+	require.NoError(t, store.PurgeExpired())
+	all, err := store.AllCookies()
+	require.NoError(t, err)
+	require.Len(t, all, 3, "Only unexpired cookies should be listed")
+
+	require.NoError(t, store.ClearHost("manage.test"))
+	require.Empty(t, store.Cookies(wwwURL))
+	require.Equal(t, "c=4", cookieHeader(store.Cookies(otherURL)))
+	hosts, err = store.Hosts()
+	require.NoError(t, err)
+	require.Equal(t, []string{"other.test"}, hosts)
+
+	require.NoError(t, store.ClearAll())
+	require.Empty(t, store.Cookies(otherURL))
+	hosts, err = store.Hosts()
+	require.NoError(t, err)
+	require.Empty(t, hosts)
+}
+
+// runProfileTest checks that profiles of store are isolated and can be
+// listed, cloned and deleted. It clears the profiles it uses.
+func runProfileTest(t *testing.T, store cookiestore.ProfileStore) {
+	t.Helper()
+	require.NoError(t, store.ClearAll())
+	for _, name := range []string{"alice", "bob", "carol", "dave"} {
+		require.NoError(t, store.DeleteProfile(name))
+	}
+
+	u, _ := url.Parse("https://www.profile.test/")
+	alice, bob := store.Profile("alice"), store.Profile("bob")
+	store.SetCookies(u, []*http.Cookie{{Name: "sid", Value: "default"}})
+	alice.SetCookies(u, []*http.Cookie{{Name: "sid", Value: "alice"}})
+	bob.SetCookies(u, []*http.Cookie{{Name: "sid", Value: "bob"}, {Name: "theme", Value: "dark"}})
+
+	require.Equal(t, "sid=default", cookieHeader(store.Cookies(u)))
+	require.Equal(t, "sid=alice", cookieHeader(alice.Cookies(u)))
+	require.ElementsMatch(t, []string{"sid=bob", "theme=dark"}, strings.Fields(cookieHeader(bob.Cookies(u))))
+	require.Equal(t, "sid=alice", cookieHeader(store.Profile("alice").Cookies(u)), "Profiles should be looked up by name")
+
+	profiles, err := store.Profiles()
+	require.NoError(t, err)
+	require.Equal(t, []string{"alice", "bob"}, profiles)
+
+	require.NoError(t, store.CloneProfile("bob", "alice"))
+	require.ElementsMatch(t, []string{"sid=bob", "theme=dark"}, strings.Fields(cookieHeader(alice.Cookies(u))))
+	require.NoError(t, store.CloneProfile("bob", "carol"))
+	bob.SetCookies(u, []*http.Cookie{{Name: "sid", Value: "changed"}})
+	require.ElementsMatch(t, []string{"sid=bob", "theme=dark"}, strings.Fields(cookieHeader(store.Profile("carol").Cookies(u))),
+		"Clones should not follow later changes")
+
+	require.NoError(t, store.DeleteProfile("bob"))
+	require.Empty(t, bob.Cookies(u))
+	profiles, err = store.Profiles()
+	require.NoError(t, err)
+	require.Equal(t, []string{"alice", "carol"}, profiles)
+
+	// A profile whose cookies have all expired is not listed.
+	store.Profile("dave").SetCookies(u, []*http.Cookie{{Name: "sid", Value: "dave", Expires: time.Now().Add(500 * time.Millisecond)}})
+	profiles, err = store.Profiles()
+	require.NoError(t, err)
+	require.Equal(t, []string{"alice", "carol", "dave"}, profiles)
+	time.Sleep(time.Second)
+	profiles, err = store.Profiles()
+	require.NoError(t, err)
+	require.Equal(t, []string{"alice", "carol"}, profiles, "Profiles with expired cookies only should not be listed")
+
+	require.NoError(t, store.Profile("alice").ClearAll())
+	require.NoError(t, store.DeleteProfile("carol"))
+	profiles, err = store.Profiles()
+	require.NoError(t, err)
+	require.Empty(t, profiles)
+	require.Equal(t, "sid=default", cookieHeader(store.Cookies(u)), "The default profile should be kept")
+	require.NoError(t, store.ClearAll())
+}
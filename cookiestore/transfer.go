@@ -0,0 +1,250 @@
+package cookiestore
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The functions in this file move cookies between stores, browsers and
+// tools like curl. Exchanged cookies follow the browser convention for
+// Domain: a leading dot marks a domain cookie, sent to subdomains too, and a
+// bare host marks a host-only cookie. AllCookies returns cookies in this
+// form and ImportCookies reads it.
+
+const netscapeHeader = "# Netscape HTTP Cookie File\n"
+
+// httpOnlyPrefix marks HttpOnly cookies in cookies.txt files.
+const httpOnlyPrefix = "#HttpOnly_"
+
+// ImportCookies stores cookies in jar as if they had been received from
+// their domain over https, so secure cookies are kept.
+func ImportCookies(jar http.CookieJar, cookies []*http.Cookie) error {
+	return ImportCookiesContext(context.Background(), jar, cookies)
+}
+
+// ImportCookiesContext is ImportCookies with a context for the backend
+// calls. When jar is an ErrCookieStore, the first failure to store a cookie
+// is returned.
+func ImportCookiesContext(ctx context.Context, jar http.CookieJar, cookies []*http.Cookie) error {
+	store, _ := jar.(ErrCookieStore)
+	for _, c := range cookies {
+		host, domainCookie := strings.CutPrefix(c.Domain, ".")
+		if host == "" {
+			return fmt.Errorf("cookie %q has no domain", c.Name)
+		}
+		path := c.Path
+		if path == "" || path[0] != '/' {
+			path = "/"
+		}
+		imported := *c
+		imported.Domain = ""
+		if domainCookie {
+			imported.Domain = host
+		}
+		imported.Path = path
+		u := &url.URL{Scheme: "https", Host: host, Path: path}
+		if store == nil {
+			jar.SetCookies(u, []*http.Cookie{&imported})
+			continue
+		}
+		if err := store.SetCookiesContext(ctx, u, []*http.Cookie{&imported}); err != nil {
+			return fmt.Errorf("import cookie %q: %w", c.Name, err)
+		}
+	}
+	return nil
+}
+
+// ReadNetscapeCookies parses a cookies.txt file as written by curl, wget and
+// browser extensions.
+func ReadNetscapeCookies(r io.Reader) ([]*http.Cookie, error) {
+	var cookies []*http.Cookie
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := false
+		if rest, ok := strings.CutPrefix(line, httpOnlyPrefix); ok {
+			line = rest
+			httpOnly = true
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("cookies.txt line %d: expected 7 tab-separated fields, got %d", lineNum, len(fields))
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cookies.txt line %d: invalid expiry %q", lineNum, fields[4])
+		}
+		domain := strings.TrimPrefix(fields[0], ".")
+		if strings.EqualFold(fields[1], "TRUE") {
+			domain = "." + domain
+		}
+		c := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Domain:   domain,
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HttpOnly: httpOnly,
+		}
+		if expires > 0 {
+			c.Expires = time.Unix(expires, 0)
+		}
+		cookies = append(cookies, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return cookies, nil
+}
+
+// WriteNetscapeCookies writes cookies in the cookies.txt format read by curl
+// (--cookie) and wget (--load-cookies). Session cookies get an expiry of 0.
+func WriteNetscapeCookies(w io.Writer, cookies []*http.Cookie) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(netscapeHeader + "\n"); err != nil {
+		return err
+	}
+	for _, c := range cookies {
+		if strings.TrimPrefix(c.Domain, ".") == "" {
+			return fmt.Errorf("cookie %q has no domain", c.Name)
+		}
+		prefix := ""
+		if c.HttpOnly {
+			prefix = httpOnlyPrefix
+		}
+		path := c.Path
+		if path == "" {
+			path = "/"
+		}
+		var expires int64
+		if !c.Expires.IsZero() {
+			expires = c.Expires.Unix()
+		}
+		fmt.Fprintf(bw, "%s%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			prefix, c.Domain, netscapeBool(strings.HasPrefix(c.Domain, ".")), path, netscapeBool(c.Secure), expires, c.Name, c.Value)
+	}
+	return bw.Flush()
+}
+
+func netscapeBool(b bool) string {
+	if b {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+// harCookie is a cookie object of the HTTP Archive format, as found in the
+// "cookies" arrays of HAR requests and responses.
+type harCookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Path     string     `json:"path,omitempty"`
+	Domain   string     `json:"domain,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	HTTPOnly bool       `json:"httpOnly,omitempty"`
+	Secure   bool       `json:"secure,omitempty"`
+	SameSite string     `json:"sameSite,omitempty"`
+}
+
+// ReadHARCookies parses a HAR cookie array.
+func ReadHARCookies(r io.Reader) ([]*http.Cookie, error) {
+	var harCookies []harCookie
+	if err := json.NewDecoder(r).Decode(&harCookies); err != nil {
+		return nil, fmt.Errorf("decode HAR cookies: %w", err)
+	}
+	cookies := make([]*http.Cookie, len(harCookies))
+	for i, hc := range harCookies {
+		cookies[i] = &http.Cookie{
+			Name:     hc.Name,
+			Value:    hc.Value,
+			Path:     hc.Path,
+			Domain:   hc.Domain,
+			HttpOnly: hc.HTTPOnly,
+			Secure:   hc.Secure,
+			SameSite: parseSameSite(hc.SameSite),
+		}
+		if hc.Expires != nil {
+			cookies[i].Expires = *hc.Expires
+		}
+	}
+	return cookies, nil
+}
+
+// WriteHARCookies writes cookies as a HAR cookie array.
+func WriteHARCookies(w io.Writer, cookies []*http.Cookie) error {
+	harCookies := make([]harCookie, len(cookies))
+	for i, c := range cookies {
+		harCookies[i] = harCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			HTTPOnly: c.HttpOnly,
+			Secure:   c.Secure,
+			SameSite: sameSiteString(c.SameSite),
+		}
+		if !c.Expires.IsZero() {
+			expires := c.Expires.UTC()
+			harCookies[i].Expires = &expires
+		}
+	}
+	return json.NewEncoder(w).Encode(harCookies)
+}
+
+// ReadJSONCookies parses a JSON array of http.Cookie values, the format
+// earlier versions of RedisCookieStore stored cookies in.
+func ReadJSONCookies(r io.Reader) ([]*http.Cookie, error) {
+	var cookies []*http.Cookie
+	if err := json.NewDecoder(r).Decode(&cookies); err != nil {
+		return nil, fmt.Errorf("decode JSON cookies: %w", err)
+	}
+	return cookies, nil
+}
+
+// WriteJSONCookies writes cookies as a JSON array of http.Cookie values.
+func WriteJSONCookies(w io.Writer, cookies []*http.Cookie) error {
+	if cookies == nil {
+		cookies = []*http.Cookie{}
+	}
+	return json.NewEncoder(w).Encode(cookies)
+}
+
+// exportCookie converts e to the form returned by AllCookies.
+func exportCookie(e entry) *http.Cookie {
+	c := e.cookie()
+	c.Domain = e.Domain
+	if !e.HostOnly {
+		c.Domain = "." + e.Domain
+	}
+	return c
+}
+
+// sortCookies orders exported cookies by domain, path and name.
+func sortCookies(cookies []*http.Cookie) {
+	sort.SliceStable(cookies, func(i, j int) bool {
+		a, b := cookies[i], cookies[j]
+		if ad, bd := strings.TrimPrefix(a.Domain, "."), strings.TrimPrefix(b.Domain, "."); ad != bd {
+			return ad < bd
+		}
+		if a.Domain != b.Domain {
+			return a.Domain < b.Domain
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.Name < b.Name
+	})
+}
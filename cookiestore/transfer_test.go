@@ -0,0 +1,131 @@
+package cookiestore_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/takumi3488/twocker/cookiestore"
+)
+
+const testCookiesTxt = `# Netscape HTTP Cookie File
+# https://curl.se/docs/http-cookies.html
+
+.example.com	TRUE	/	FALSE	4102444800	session	abc123
+#HttpOnly_www.example.com	FALSE	/account	TRUE	0	token	secret
+www.example.com	FALSE	/	FALSE	0	empty	
+`
+
+func TestReadNetscapeCookies(t *testing.T) {
+	cookies, err := cookiestore.ReadNetscapeCookies(strings.NewReader(testCookiesTxt))
+	require.NoError(t, err)
+	require.Len(t, cookies, 3)
+
+	require.Equal(t, "session", cookies[0].Name)
+	require.Equal(t, ".example.com", cookies[0].Domain)
+	require.Equal(t, time.Unix(4102444800, 0), cookies[0].Expires)
+
+	require.Equal(t, "www.example.com", cookies[1].Domain)
+	require.Equal(t, "/account", cookies[1].Path)
+	require.True(t, cookies[1].HttpOnly)
+	require.True(t, cookies[1].Secure)
+	require.True(t, cookies[1].Expires.IsZero())
+
+	require.Equal(t, "", cookies[2].Value)
+
+	_, err = cookiestore.ReadNetscapeCookies(strings.NewReader("example.com\tTRUE\t/\n"))
+	require.Error(t, err)
+}
+
+func TestImportAndExportCookies(t *testing.T) {
+	cookies, err := cookiestore.ReadNetscapeCookies(strings.NewReader(testCookiesTxt))
+	require.NoError(t, err)
+	store := cookiestore.NewInMemoryCookieStore()
+	require.NoError(t, cookiestore.ImportCookies(store, cookies))
+
+	u, _ := url.Parse("https://www.example.com/account")
+	require.Equal(t, "token=secret session=abc123 empty=", cookieHeader(store.Cookies(u)))
+	u, _ = url.Parse("https://api.example.com/")
+	require.Equal(t, "session=abc123", cookieHeader(store.Cookies(u)))
+
+	exported, err := store.AllCookies()
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, cookiestore.WriteNetscapeCookies(&buf, exported))
+	require.Equal(t, `# Netscape HTTP Cookie File
+
+.example.com	TRUE	/	FALSE	4102444800	session	abc123
+www.example.com	FALSE	/	FALSE	0	empty	
+#HttpOnly_www.example.com	FALSE	/account	TRUE	0	token	secret
+`, buf.String())
+}
+
+func TestHARCookies(t *testing.T) {
+	har := `[
+		{"name": "a", "value": "1", "domain": ".example.com", "path": "/", "expires": "2100-01-01T00:00:00.000Z", "httpOnly": true, "secure": true, "sameSite": "Lax"},
+		{"name": "b", "value": "2", "domain": "www.example.com", "path": "/"}
+	]`
+	cookies, err := cookiestore.ReadHARCookies(strings.NewReader(har))
+	require.NoError(t, err)
+	require.Len(t, cookies, 2)
+	require.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite)
+	require.Equal(t, time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC), cookies[0].Expires)
+
+	store := cookiestore.NewInMemoryCookieStore()
+	require.NoError(t, cookiestore.ImportCookies(store, cookies))
+	exported, err := store.AllCookies()
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, cookiestore.WriteHARCookies(&buf, exported))
+	roundTripped, err := cookiestore.ReadHARCookies(&buf)
+	require.NoError(t, err)
+	require.Len(t, roundTripped, 2)
+	for i := range cookies {
+		require.Equal(t, cookies[i].Name, roundTripped[i].Name)
+		require.Equal(t, cookies[i].Domain, roundTripped[i].Domain)
+		require.True(t, cookies[i].Expires.Equal(roundTripped[i].Expires))
+		require.Equal(t, cookies[i].HttpOnly, roundTripped[i].HttpOnly)
+		require.Equal(t, cookies[i].SameSite, roundTripped[i].SameSite)
+	}
+}
+
+func TestJSONCookies(t *testing.T) {
+	cookies := []*http.Cookie{
+		{Name: "a", Value: "1", Domain: ".example.com", Path: "/", HttpOnly: true},
+		{Name: "b", Value: "2", Domain: "www.example.com", Path: "/"},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, cookiestore.WriteJSONCookies(&buf, cookies))
+	read, err := cookiestore.ReadJSONCookies(&buf)
+	require.NoError(t, err)
+	compareCookieSlices(t, cookies, read)
+
+	store := cookiestore.NewInMemoryCookieStore()
+	require.NoError(t, cookiestore.ImportCookies(store, read))
+	u, _ := url.Parse("http://sub.example.com/")
+	require.Equal(t, "a=1", cookieHeader(store.Cookies(u)))
+
+	require.Error(t, cookiestore.ImportCookies(store, []*http.Cookie{{Name: "c", Value: "3"}}))
+}
+
+func TestImportCookiesBackendError(t *testing.T) {
+	store, err := cookiestore.NewSQLiteCookieStore(filepath.Join(t.TempDir(), "cookies.db"), nil)
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	cookies := []*http.Cookie{{Name: "a", Value: "1", Domain: ".example.com", Path: "/"}}
+	require.Error(t, cookiestore.ImportCookies(store, cookies), "Backend failures should be returned")
+
+	memory := cookiestore.NewInMemoryCookieStore()
+	require.NoError(t, cookiestore.ImportCookiesContext(context.Background(), memory, cookies))
+	u, _ := url.Parse("https://www.example.com/")
+	require.Equal(t, "a=1", cookieHeader(memory.Cookies(u)))
+}
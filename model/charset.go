@@ -0,0 +1,57 @@
+package model
+
+import (
+	"bytes"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+var utf8BOM = []byte("\xEF\xBB\xBF")
+
+// WithCharset makes responses decode their body from label (e.g.
+// "shift_jis") instead of detecting the charset. Unknown labels are ignored.
+func (c *TwockerClient) WithCharset(label string) *TwockerClient {
+	c.charset = label
+	return c
+}
+
+// detectEncoding picks the body's encoding from the override, a BOM, the
+// Content-Type charset or a <meta> tag, in that order, falling back to UTF-8
+// or windows-1252. certain is false when the charset was only guessed.
+func detectEncoding(body []byte, contentType string, override string) (e encoding.Encoding, name string, certain bool) {
+	if override != "" {
+		if e, name := charset.Lookup(override); e != nil {
+			return e, name, true
+		}
+	}
+	e, name, certain = charset.DetermineEncoding(body, contentType)
+	// DetermineEncoding only sniffs the first 1024 bytes and guesses
+	// windows-1252 when they are ASCII, so that guess is overruled by a body
+	// that is valid UTF-8 as a whole. A declared charset is kept.
+	if !certain && name == "windows-1252" && utf8.Valid(body) && !declaresCharset(body) {
+		e, name = charset.Lookup("utf-8")
+	}
+	return e, name, certain
+}
+
+// declaresCharset reports whether the ASCII bytes sniffed by
+// DetermineEncoding contain a <meta> charset. Without one, they are detected
+// as UTF-8 once a complete non-ASCII character follows them.
+func declaresCharset(body []byte) bool {
+	const probe = "é."
+	n := min(len(body), 1024-len(probe))
+	_, name, _ := charset.DetermineEncoding(append(body[:n:n], probe...), "")
+	return name != "utf-8"
+}
+
+func decodeToUTF8(body []byte, e encoding.Encoding) []byte {
+	decoded := body
+	if e != encoding.Nop {
+		if b, err := e.NewDecoder().Bytes(body); err == nil {
+			decoded = b
+		}
+	}
+	return bytes.TrimPrefix(decoded, utf8BOM)
+}
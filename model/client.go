@@ -1,13 +1,23 @@
 package model
 
 import (
+	"context"
+	"errors"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
+	"time"
 )
 
 type TwockerClient struct {
-	Client *http.Client
+	Client      *http.Client
+	retryPolicy *RetryPolicy
+	rateLimiter *RateLimiter
+	robots      *robotsCache
+	charset     string
+	logger      *slog.Logger
 }
 
 func NewTwockerClient() *TwockerClient {
@@ -27,49 +37,188 @@ func (c *TwockerClient) WithCheckRedirect(checkRedirect func(req *http.Request,
 }
 
 func (c *TwockerClient) Get(url string, headers [][2]string) (*TwockerResponse, error) {
-	return command(c, http.MethodGet, url, nil, headers)
+	return c.GetContext(context.Background(), url, headers)
 }
 
 func (c *TwockerClient) Post(url string, body io.Reader, headers [][2]string) (*TwockerResponse, error) {
-	return command(c, http.MethodPost, url, body, headers)
+	return c.PostContext(context.Background(), url, body, headers)
 }
 
 func (c *TwockerClient) Patch(url string, body io.Reader, headers [][2]string) (*TwockerResponse, error) {
-	return command(c, http.MethodPatch, url, body, headers)
+	return c.PatchContext(context.Background(), url, body, headers)
 }
 
 func (c *TwockerClient) Delete(url string, body io.Reader, headers [][2]string) (*TwockerResponse, error) {
-	return command(c, http.MethodDelete, url, body, headers)
+	return c.DeleteContext(context.Background(), url, body, headers)
 }
 
 func (c *TwockerClient) Put(url string, contentType string, body io.Reader, headers [][2]string) (*TwockerResponse, error) {
-	return command(c, http.MethodPut, url, body, headers)
+	return c.PutContext(context.Background(), url, contentType, body, headers)
 }
 
-func command(c *TwockerClient, method string, url string, body io.Reader, headers [][2]string) (*TwockerResponse, error) {
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return &TwockerResponse{}, nil
-	}
-	for _, header := range headers {
-		req.Header.Add(header[0], header[1])
+func (c *TwockerClient) GetContext(ctx context.Context, url string, headers [][2]string) (*TwockerResponse, error) {
+	return command(ctx, c, http.MethodGet, url, nil, headers)
+}
+
+func (c *TwockerClient) PostContext(ctx context.Context, url string, body io.Reader, headers [][2]string) (*TwockerResponse, error) {
+	return command(ctx, c, http.MethodPost, url, body, headers)
+}
+
+func (c *TwockerClient) PatchContext(ctx context.Context, url string, body io.Reader, headers [][2]string) (*TwockerResponse, error) {
+	return command(ctx, c, http.MethodPatch, url, body, headers)
+}
+
+func (c *TwockerClient) DeleteContext(ctx context.Context, url string, body io.Reader, headers [][2]string) (*TwockerResponse, error) {
+	return command(ctx, c, http.MethodDelete, url, body, headers)
+}
+
+func (c *TwockerClient) PutContext(ctx context.Context, url string, contentType string, body io.Reader, headers [][2]string) (*TwockerResponse, error) {
+	if contentType != "" && !hasHeader(headers, "Content-Type") {
+		headers = append([][2]string{{"Content-Type", contentType}}, headers...)
 	}
+	return command(ctx, c, http.MethodPut, url, body, headers)
+}
 
-	resp, err := c.Client.Do(req)
+func command(ctx context.Context, c *TwockerClient, method string, url string, body io.Reader, headers [][2]string) (*TwockerResponse, error) {
+	req, err := newRequest(ctx, method, url, body, headers)
+	if err != nil {
+		return nil, err
+	}
 
+	resp, trace, attempts, err := c.send(req)
 	if err != nil {
-		return &TwockerResponse{}, nil
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	b, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return &TwockerResponse{}, nil
+		reqErr := newRequestError(ErrReadBody, method, url, req, err)
+		reqErr.Attempts = attempts
+		return nil, reqErr
+	}
+
+	r := newTwockerResponseFromHTTP(resp, b, trace.finish())
+	r.attempts = attempts
+	r.charsetOverride = c.charset
+	return r, nil
+}
+
+func newRequest(ctx context.Context, method string, url string, body io.Reader, headers [][2]string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, newRequestError(ErrBuildRequest, method, url, nil, err)
+	}
+	for _, header := range headers {
+		req.Header.Add(header[0], header[1])
+	}
+	return req, nil
+}
+
+// send performs req, retrying it according to c's retry policy. It returns
+// the final response with its body still open, the trace of the attempt that
+// produced it and the number of attempts made.
+func (c *TwockerClient) send(req *http.Request) (*http.Response, *timingTrace, int, error) {
+	start := time.Now()
+	resp, trace, attempts, err := c.sendAttempts(req)
+	if c.logger != nil {
+		c.logResult(req, resp, attempts, err, start)
 	}
+	return resp, trace, attempts, err
+}
 
-	reqUrl := resp.Request.URL
+func (c *TwockerClient) sendAttempts(req *http.Request) (*http.Response, *timingTrace, int, error) {
+	ctx := req.Context()
+	if c.robots != nil {
+		if err := c.robots.check(c, req); err != nil {
+			return nil, nil, 0, err
+		}
+	}
 
-	return NewTwockerResponse(resp.StatusCode, b, reqUrl), nil
+	replayable := false
+	if c.retryPolicy.enabled() {
+		var err error
+		replayable, err = prepareReplay(req)
+		if err != nil {
+			return nil, nil, 0, newRequestError(ErrBuildRequest, req.Method, "", req, err)
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		trace := newTimingTrace()
+		attemptReq := req.WithContext(httptrace.WithClientTrace(ctx, trace.clientTrace()))
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, nil, attempt, newRequestError(ErrBuildRequest, req.Method, "", req, err)
+			}
+			attemptReq.Body = body
+		}
+
+		c.log(ctx, slog.LevelDebug, "sending request", "method", req.Method, "url", redactURL(req.URL), "attempt", attempt)
+		var err error
+		resp, doErr := c.httpClient(ctx).Do(attemptReq)
+		if doErr != nil {
+			// Errors of hopTransport already describe the hop that failed.
+			var reqErr *RequestError
+			if !errors.As(doErr, &reqErr) {
+				reqErr = newRequestError(classifyTransportError(doErr), req.Method, "", attemptReq, doErr)
+			}
+			reqErr.Attempts = attempt
+			err = reqErr
+		}
+		if !replayable || attempt >= c.retryPolicy.MaxAttempts || ctx.Err() != nil || !c.retryPolicy.shouldRetry(req, resp, err) {
+			return resp, trace, attempt, err
+		}
+		wait, ok := c.retryPolicy.wait(attempt, resp)
+		if !ok {
+			return resp, trace, attempt, nil
+		}
+		retryArgs := []any{"method", req.Method, "url", redactURL(req.URL), "attempt", attempt, "wait", wait}
+		if resp != nil {
+			retryArgs = append(retryArgs, "status", resp.StatusCode)
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		} else {
+			retryArgs = append(retryArgs, "error", redactError(doErr))
+		}
+		c.log(ctx, slog.LevelWarn, "retrying request", retryArgs...)
+
+		if err := sleepContext(ctx, wait); err != nil {
+			reqErr := newRequestError(classifyTransportError(err), req.Method, "", req, err)
+			reqErr.Attempts = attempt
+			return nil, nil, attempt, reqErr
+		}
+	}
+}
+
+// contextJar matches cookiestore.ContextJar. It is declared here so that
+// the client does not link the cookie store backends.
+type contextJar interface {
+	http.CookieJar
+	WithContext(ctx context.Context) http.CookieJar
+}
+
+// httpClient returns c.Client, or a shallow copy of it whose cookie jar is
+// bound to ctx when the jar supports it and whose hops are checked by
+// hopTransport.
+func (c *TwockerClient) httpClient(ctx context.Context) *http.Client {
+	jar, ok := c.Client.Jar.(contextJar)
+	if !ok && c.rateLimiter == nil && c.robots == nil {
+		return c.Client
+	}
+	client := *c.Client
+	if ok {
+		client.Jar = jar.WithContext(ctx)
+	}
+	if c.rateLimiter != nil || c.robots != nil {
+		base := client.Transport
+		if base == nil {
+			base = http.DefaultTransport
+		}
+		client.Transport = &hopTransport{base: base, c: c}
+	}
+	return &client
 }
 
 func (c *TwockerClient) Cookies(url *url.URL) []*http.Cookie {
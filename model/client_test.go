@@ -2,10 +2,12 @@ package model
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/takumi3488/twocker/cookiestore"
 	"github.com/testcontainers/testcontainers-go"
@@ -104,3 +106,58 @@ func cookieTestHelper(t *testing.T, cookieStore http.CookieJar, kaisuu string) {
 	}
 	t.Errorf("Expected cookie ENC_KAISUU to be set")
 }
+
+type ctxKey struct{}
+
+type recordingContextJar struct {
+	*cookiestore.InMemoryCookieStore
+	seen []context.Context
+}
+
+func (j *recordingContextJar) WithContext(ctx context.Context) http.CookieJar {
+	j.seen = append(j.seen, ctx)
+	return j.InMemoryCookieStore
+}
+
+func TestTwockerClientGetContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	jar := &recordingContextJar{InMemoryCookieStore: cookiestore.NewInMemoryCookieStore()}
+	c := NewTwockerClient().WithCookieJar(jar)
+	ctx := context.WithValue(context.Background(), ctxKey{}, "scrape-1")
+	resp, err := c.GetContext(ctx, server.URL, nil)
+	if err != nil {
+		t.Fatalf("Error making GET request: %v", err)
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected status code 204, got %d", resp.StatusCode)
+	}
+	if len(jar.seen) == 0 {
+		t.Fatalf("Expected cookie jar to be bound to the request context")
+	}
+	for _, seen := range jar.seen {
+		if seen.Value(ctxKey{}) != "scrape-1" {
+			t.Errorf("Cookie jar was bound to a different context")
+		}
+	}
+}
+
+func TestTwockerClientContextCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := NewTwockerClient().GetContext(ctx, server.URL, nil)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected ErrTimeout, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
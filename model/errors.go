@@ -0,0 +1,92 @@
+package model
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// Sentinel errors describing why a request failed. A *RequestError matches
+// exactly one of them with errors.Is.
+var (
+	ErrBuildRequest = errors.New("twocker: failed to build request")
+	ErrTransport    = errors.New("twocker: transport error")
+	ErrReadBody     = errors.New("twocker: failed to read response body")
+	ErrTimeout      = errors.New("twocker: request timed out")
+	ErrTLS          = errors.New("twocker: tls error")
+	ErrRateLimit    = errors.New("twocker: rate limiter failed")
+
+	ErrRobotsDisallowed = errors.New("twocker: disallowed by robots.txt")
+)
+
+// RequestError is returned by every TwockerClient verb when a request cannot
+// be completed. Request is nil when the request could not be built, and
+// Attempts counts the attempts made under the client's retry policy.
+type RequestError struct {
+	Kind     error
+	Method   string
+	URL      string
+	Request  *http.Request
+	Attempts int
+	Err      error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("%s: %s %s: %v", e.Kind, e.Method, e.URL, e.Err)
+}
+
+func (e *RequestError) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+func newRequestError(kind error, method string, rawURL string, req *http.Request, err error) *RequestError {
+	if req != nil {
+		rawURL = req.URL.String()
+	}
+	return &RequestError{
+		Kind:    kind,
+		Method:  method,
+		URL:     rawURL,
+		Request: req,
+		Err:     err,
+	}
+}
+
+// classifyTransportError picks the most specific kind for an error returned
+// by http.Client.Do.
+func classifyTransportError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrTimeout
+	}
+	if isTLSError(err) {
+		return ErrTLS
+	}
+	return ErrTransport
+}
+
+func isTLSError(err error) bool {
+	var (
+		verifyErr    *tls.CertificateVerificationError
+		recordErr    tls.RecordHeaderError
+		alertErr     tls.AlertError
+		unknownErr   x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+		echRejection *tls.ECHRejectionError
+	)
+	return errors.As(err, &verifyErr) ||
+		errors.As(err, &recordErr) ||
+		errors.As(err, &alertErr) ||
+		errors.As(err, &unknownErr) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &invalidErr) ||
+		errors.As(err, &echRejection)
+}
@@ -0,0 +1,86 @@
+package model
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestErrorBuild(t *testing.T) {
+	c := NewTwockerClient()
+	resp, err := c.Get("http://[::1", nil)
+	if resp != nil {
+		t.Errorf("Expected nil response, got %v", resp)
+	}
+	if !errors.Is(err, ErrBuildRequest) {
+		t.Fatalf("Expected ErrBuildRequest, got %v", err)
+	}
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) {
+		t.Fatalf("Expected *RequestError, got %T", err)
+	}
+	if reqErr.Method != http.MethodGet || reqErr.URL != "http://[::1" || reqErr.Request != nil {
+		t.Errorf("Unexpected error fields: %+v", reqErr)
+	}
+}
+
+func TestRequestErrorTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serverURL := server.URL
+	server.Close()
+
+	_, err := NewTwockerClient().Post(serverURL, nil, nil)
+	if !errors.Is(err, ErrTransport) {
+		t.Fatalf("Expected ErrTransport, got %v", err)
+	}
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) || reqErr.Request == nil {
+		t.Fatalf("Expected *RequestError with request attached, got %v", err)
+	}
+	if reqErr.Request.Method != http.MethodPost {
+		t.Errorf("Expected attached request method POST, got %s", reqErr.Request.Method)
+	}
+}
+
+func TestRequestErrorTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	c := NewTwockerClient()
+	c.Client.Timeout = 20 * time.Millisecond
+	_, err := c.Get(server.URL, nil)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Expected ErrTimeout, got %v", err)
+	}
+	if errors.Is(err, ErrTransport) {
+		t.Errorf("Timeout should not also match ErrTransport")
+	}
+}
+
+func TestRequestErrorTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	_, err := NewTwockerClient().Get(server.URL, nil)
+	if !errors.Is(err, ErrTLS) {
+		t.Fatalf("Expected ErrTLS, got %v", err)
+	}
+}
+
+func TestRequestErrorReadBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("short"))
+	}))
+	defer server.Close()
+
+	_, err := NewTwockerClient().Get(server.URL, nil)
+	if !errors.Is(err, ErrReadBody) {
+		t.Fatalf("Expected ErrReadBody, got %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// WithLogger makes the client log requests, retries and failures to logger:
+// attempts at debug level, completed requests at info level, retries and
+// failures at warn level. URLs are logged without credentials and with query
+// values redacted; headers, cookies and bodies are never logged, only the
+// number of cookies a response sets.
+func (c *TwockerClient) WithLogger(logger *slog.Logger) *TwockerClient {
+	c.logger = logger
+	return c
+}
+
+func (c *TwockerClient) log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	if c.logger == nil || !c.logger.Enabled(ctx, level) {
+		return
+	}
+	c.logger.Log(ctx, level, msg, args...)
+}
+
+// logResult logs the outcome of send.
+func (c *TwockerClient) logResult(req *http.Request, resp *http.Response, attempts int, err error, start time.Time) {
+	ctx := req.Context()
+	args := []any{
+		"method", req.Method,
+		"url", redactURL(req.URL),
+		"host", req.URL.Hostname(),
+		"attempts", attempts,
+		"duration", time.Since(start),
+	}
+	if err == nil {
+		args = append(args, "status", resp.StatusCode, "set_cookies", len(resp.Cookies()))
+		c.log(ctx, slog.LevelInfo, "request completed", args...)
+		return
+	}
+
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) {
+		c.log(ctx, slog.LevelWarn, "request failed", append(args, "error", err)...)
+		return
+	}
+	if errors.Is(reqErr.Kind, ErrRobotsDisallowed) {
+		c.log(ctx, slog.LevelInfo, "request disallowed by robots.txt", args...)
+		return
+	}
+	c.log(ctx, slog.LevelWarn, "request failed", append(args, "kind", reqErr.Kind, "error", redactError(reqErr.Err))...)
+}
+
+// redactError drops the URL that *url.Error adds to transport errors.
+func redactError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// redactURL returns u without its password and with every query value
+// replaced, since both may carry credentials.
+func redactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	redacted := *u
+	redacted.Fragment = ""
+	redacted.RawFragment = ""
+	if u.RawQuery != "" {
+		query := u.Query()
+		for key, values := range query {
+			for i := range values {
+				values[i] = "REDACTED"
+			}
+			query[key] = values
+		}
+		redacted.RawQuery = query.Encode()
+	}
+	return redacted.Redacted()
+}
@@ -0,0 +1,105 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// logRecords decodes the JSON lines written by a slog.JSONHandler.
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Invalid log line %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestWithLogger(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "cookie-secret"})
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	buf := &bytes.Buffer{}
+	logger := slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	c := NewTwockerClient().WithRetryPolicy(fastRetryPolicy()).WithLogger(logger)
+	if _, err := c.Get(server.URL+"/path?token=query-secret", nil); err != nil {
+		t.Fatalf("Error making GET request: %v", err)
+	}
+
+	var messages []string
+	for _, record := range logRecords(t, buf) {
+		messages = append(messages, record["msg"].(string))
+	}
+	want := []string{"sending request", "retrying request", "sending request", "request completed"}
+	if strings.Join(messages, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected messages %v, got %v", want, messages)
+	}
+	completed := logRecords(t, buf)[3]
+	if completed["level"] != "INFO" || completed["status"] != float64(200) || completed["attempts"] != float64(2) || completed["set_cookies"] != float64(1) {
+		t.Errorf("Unexpected completion record %v", completed)
+	}
+	if !strings.Contains(completed["url"].(string), "/path?token=REDACTED") {
+		t.Errorf("Expected the query value to be redacted, got %v", completed["url"])
+	}
+	if strings.Contains(buf.String(), "query-secret") || strings.Contains(buf.String(), "cookie-secret") {
+		t.Errorf("Secrets leaked into the log: %s", buf.String())
+	}
+}
+
+func TestWithLoggerRequestFailed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serverURL := server.URL
+	server.Close()
+
+	buf := &bytes.Buffer{}
+	c := NewTwockerClient().WithLogger(slog.New(slog.NewJSONHandler(buf, nil)))
+	if _, err := c.Get(serverURL+"/?password=query-secret", nil); err == nil {
+		t.Fatal("Expected an error from a closed server")
+	}
+
+	records := logRecords(t, buf)
+	if len(records) != 1 || records[0]["msg"] != "request failed" || records[0]["level"] != "WARN" {
+		t.Fatalf("Expected one request failed warning, got %v", records)
+	}
+	if records[0]["error"] == "" || strings.Contains(buf.String(), "query-secret") {
+		t.Errorf("Expected a redacted error, got %s", buf.String())
+	}
+}
+
+func TestWithoutLogger(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	buf := &bytes.Buffer{}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer slog.SetDefault(previous)
+
+	if _, err := NewTwockerClient().Get(server.URL, nil); err != nil {
+		t.Fatalf("Error making GET request: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("A client without a logger should not log, got %s", buf.String())
+	}
+}
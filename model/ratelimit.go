@@ -0,0 +1,305 @@
+package model
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimitSweepInterval is how often the per-host state of idle hosts is
+// dropped.
+const rateLimitSweepInterval = time.Minute
+
+// RateLimit throttles requests to a single host. Zero values disable the
+// corresponding limit.
+type RateLimit struct {
+	RequestsPerSecond float64
+	// Burst is the number of requests allowed back to back before
+	// RequestsPerSecond applies. Values below 1 are treated as 1.
+	Burst         int
+	MaxConcurrent int
+	// MinDelay is the minimum time between the starts of two requests, to
+	// which a random delay of up to Jitter is added.
+	MinDelay time.Duration
+	Jitter   time.Duration
+}
+
+// RateLimitBackend keeps the request budget of each host. Reserve takes one
+// request slot from the budget of host, which refills one slot every
+// interval up to burst slots, and returns how long the caller must wait
+// before using it.
+type RateLimitBackend interface {
+	Reserve(ctx context.Context, host string, interval time.Duration, burst int) (time.Duration, error)
+}
+
+// RateLimiter throttles the requests of a TwockerClient per host. Request
+// budgets are kept by its backend, which defaults to process memory; the
+// concurrency cap and minimum delay always apply to this process only.
+type RateLimiter struct {
+	mu           sync.Mutex
+	defaultLimit RateLimit
+	hostLimits   map[string]RateLimit
+	backend      RateLimitBackend
+	hosts        map[string]*hostThrottle
+	crawlDelays  map[string]time.Duration
+	swept        time.Time
+}
+
+type hostThrottle struct {
+	// refs counts the acquire calls using the throttle. It is guarded by
+	// RateLimiter.mu.
+	refs      int
+	mu        sync.Mutex
+	slots     chan struct{}
+	nextStart time.Time
+}
+
+func NewRateLimiter(defaultLimit RateLimit) *RateLimiter {
+	return &RateLimiter{
+		defaultLimit: defaultLimit,
+		hostLimits:   make(map[string]RateLimit),
+		backend:      newLocalRateLimitBackend(),
+		hosts:        make(map[string]*hostThrottle),
+		crawlDelays:  make(map[string]time.Duration),
+	}
+}
+
+func (c *TwockerClient) WithRateLimiter(limiter *RateLimiter) *TwockerClient {
+	c.rateLimiter = limiter
+	return c
+}
+
+// SetHostLimit overrides the default limit for host. Requests already in
+// flight keep the concurrency cap they started under.
+func (l *RateLimiter) SetHostLimit(host string, limit RateLimit) *RateLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	host = strings.ToLower(host)
+	l.hostLimits[host] = limit
+	delete(l.hosts, host)
+	return l
+}
+
+// WithBackend replaces the in-process request budget, e.g. with
+// cookiestore.RedisRateLimitBackend to share it between processes.
+func (l *RateLimiter) WithBackend(backend RateLimitBackend) *RateLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.backend = backend
+	return l
+}
+
+// setCrawlDelay raises the minimum delay of host to a robots.txt
+// Crawl-delay.
+func (l *RateLimiter) setCrawlDelay(host string, delay time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.crawlDelays[strings.ToLower(host)] = delay
+}
+
+// limit returns the limit of host and its throttle, which must be released
+// with unref.
+func (l *RateLimiter) limit(host string) (RateLimit, *hostThrottle, RateLimitBackend) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now := time.Now(); now.Sub(l.swept) >= rateLimitSweepInterval {
+		l.sweep(now)
+	}
+	limit, ok := l.hostLimits[host]
+	if !ok {
+		limit = l.defaultLimit
+	}
+	limit.MinDelay = max(limit.MinDelay, l.crawlDelays[host])
+	throttle, ok := l.hosts[host]
+	if !ok {
+		throttle = &hostThrottle{}
+		if limit.MaxConcurrent > 0 {
+			throttle.slots = make(chan struct{}, limit.MaxConcurrent)
+		}
+		l.hosts[host] = throttle
+	}
+	throttle.refs++
+	return limit, throttle, l.backend
+}
+
+func (l *RateLimiter) unref(throttle *hostThrottle) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	throttle.refs--
+}
+
+// sweep drops the throttles that are not in use and whose minimum delay has
+// passed. l.mu must be held.
+func (l *RateLimiter) sweep(now time.Time) {
+	for host, throttle := range l.hosts {
+		throttle.mu.Lock()
+		idle := throttle.refs == 0 && !throttle.nextStart.After(now)
+		throttle.mu.Unlock()
+		if idle {
+			delete(l.hosts, host)
+		}
+	}
+	l.swept = now
+}
+
+// acquire blocks until a request to host may start. The returned function
+// must be called once the request has finished.
+func (l *RateLimiter) acquire(ctx context.Context, host string) (func(), error) {
+	host = strings.ToLower(host)
+	limit, throttle, backend := l.limit(host)
+
+	var once sync.Once
+	held := false
+	release := func() {
+		once.Do(func() {
+			if held {
+				<-throttle.slots
+			}
+			l.unref(throttle)
+		})
+	}
+	if throttle.slots != nil {
+		select {
+		case throttle.slots <- struct{}{}:
+			held = true
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+
+	if limit.RequestsPerSecond > 0 {
+		interval := time.Duration(float64(time.Second) / limit.RequestsPerSecond)
+		wait, err := backend.Reserve(ctx, host, interval, max(limit.Burst, 1))
+		if err != nil {
+			release()
+			return nil, err
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	if limit.MinDelay > 0 || limit.Jitter > 0 {
+		throttle.mu.Lock()
+		now := time.Now()
+		start := now
+		if throttle.nextStart.After(now) {
+			start = throttle.nextStart
+		}
+		delay := limit.MinDelay
+		if limit.Jitter > 0 {
+			delay += rand.N(limit.Jitter)
+		}
+		throttle.nextStart = start.Add(delay)
+		throttle.mu.Unlock()
+		if err := sleepContext(ctx, start.Sub(now)); err != nil {
+			release()
+			return nil, err
+		}
+	}
+	return release, nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// localRateLimitBackend implements the generic cell rate algorithm in
+// process memory, the same algorithm the Redis backend runs server side.
+type localRateLimitBackend struct {
+	mu    sync.Mutex
+	tat   map[string]time.Time
+	swept time.Time
+}
+
+func newLocalRateLimitBackend() *localRateLimitBackend {
+	return &localRateLimitBackend{tat: make(map[string]time.Time)}
+}
+
+func (b *localRateLimitBackend) Reserve(ctx context.Context, host string, interval time.Duration, burst int) (time.Duration, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if now.Sub(b.swept) >= rateLimitSweepInterval {
+		// A theoretical arrival time in the past is the same as none.
+		for h, tat := range b.tat {
+			if tat.Before(now) {
+				delete(b.tat, h)
+			}
+		}
+		b.swept = now
+	}
+	tat := b.tat[host]
+	if tat.Before(now) {
+		tat = now
+	}
+	tat = tat.Add(interval)
+	b.tat[host] = tat
+	return max(tat.Add(-interval*time.Duration(burst)).Sub(now), 0), nil
+}
+
+// hopTransport applies robots.txt and the rate limiter to every hop of a
+// request, so that redirects are checked and throttled too. The client has
+// already checked the first hop against robots.txt. A hop holds its rate
+// limiter slot until its response body is closed, which http.Client does
+// before following a redirect.
+type hopTransport struct {
+	base http.RoundTripper
+	c    *TwockerClient
+}
+
+func (t *hopTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if t.c.robots != nil && req.Response != nil && ctx.Value(robotsFetchKey{}) == nil {
+		if err := t.c.robots.check(t.c, req); err != nil {
+			return nil, err
+		}
+	}
+	if t.c.rateLimiter == nil {
+		return t.base.RoundTrip(req)
+	}
+	release, err := t.c.rateLimiter.acquire(ctx, req.URL.Hostname())
+	if err != nil {
+		kind := ErrRateLimit
+		if ctx.Err() != nil {
+			kind = classifyTransportError(ctx.Err())
+		}
+		return nil, newRequestError(kind, req.Method, "", req, err)
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	resp.Body = &releaseOnClose{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releaseOnClose releases a rate limiter slot once the response body it
+// wraps is closed.
+type releaseOnClose struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *releaseOnClose) Close() error {
+	err := r.ReadCloser.Close()
+	r.release()
+	return err
+}
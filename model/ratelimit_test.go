@@ -0,0 +1,270 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRequestsPerSecond(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	c := NewTwockerClient().WithRateLimiter(NewRateLimiter(RateLimit{RequestsPerSecond: 20, Burst: 2}))
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if _, err := c.Get(server.URL, nil); err != nil {
+			t.Fatalf("Error making GET request: %v", err)
+		}
+	}
+	// Two requests fit in the burst, the other four wait 50ms each.
+	if elapsed := time.Since(start); elapsed < 190*time.Millisecond {
+		t.Errorf("Expected requests to be throttled, took %v", elapsed)
+	}
+}
+
+func TestRateLimiterMaxConcurrent(t *testing.T) {
+	var current, peak atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := current.Add(1)
+		defer current.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	limiter := NewRateLimiter(RateLimit{}).SetHostLimit("127.0.0.1", RateLimit{MaxConcurrent: 2})
+	c := NewTwockerClient().WithRateLimiter(limiter)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Get(server.URL, nil); err != nil {
+				t.Errorf("Error making GET request: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if peak.Load() > 2 {
+		t.Errorf("Expected at most 2 concurrent requests, saw %d", peak.Load())
+	}
+}
+
+func TestRateLimiterMinDelay(t *testing.T) {
+	var mu sync.Mutex
+	var starts []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		starts = append(starts, time.Now())
+	}))
+	defer server.Close()
+
+	c := NewTwockerClient().WithRateLimiter(NewRateLimiter(RateLimit{MinDelay: 30 * time.Millisecond, Jitter: 10 * time.Millisecond}))
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(server.URL, nil); err != nil {
+			t.Fatalf("Error making GET request: %v", err)
+		}
+	}
+	for i := 1; i < len(starts); i++ {
+		if gap := starts[i].Sub(starts[i-1]); gap < 25*time.Millisecond {
+			t.Errorf("Expected at least 30ms between requests, got %v", gap)
+		}
+	}
+}
+
+func TestRateLimiterContextCanceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	c := NewTwockerClient().WithRateLimiter(NewRateLimiter(RateLimit{RequestsPerSecond: 1}))
+	if _, err := c.Get(server.URL, nil); err != nil {
+		t.Fatalf("Error making GET request: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := c.GetContext(ctx, server.URL, nil)
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("Expected ErrTimeout while waiting for the rate limiter, got %v", err)
+	}
+}
+
+type failingRateLimitBackend struct{}
+
+func (failingRateLimitBackend) Reserve(context.Context, string, time.Duration, int) (time.Duration, error) {
+	return 0, errors.New("backend down")
+}
+
+func TestRateLimiterBackendError(t *testing.T) {
+	limiter := NewRateLimiter(RateLimit{RequestsPerSecond: 1}).WithBackend(failingRateLimitBackend{})
+	_, err := NewTwockerClient().WithRateLimiter(limiter).Get("http://127.0.0.1:1", nil)
+	if !errors.Is(err, ErrRateLimit) {
+		t.Errorf("Expected ErrRateLimit, got %v", err)
+	}
+}
+
+func TestLocalRateLimitBackend(t *testing.T) {
+	b := newLocalRateLimitBackend()
+	ctx := context.Background()
+	var waits []time.Duration
+	for i := 0; i < 4; i++ {
+		wait, _ := b.Reserve(ctx, "example.com", 100*time.Millisecond, 2)
+		waits = append(waits, wait.Round(10*time.Millisecond))
+	}
+	want := []time.Duration{0, 0, 100 * time.Millisecond, 200 * time.Millisecond}
+	for i := range want {
+		if waits[i] != want[i] {
+			t.Errorf("Reserve #%d: expected wait %v, got %v", i, want[i], waits[i])
+		}
+	}
+	if wait, _ := b.Reserve(ctx, "other.example", 100*time.Millisecond, 1); wait != 0 {
+		t.Errorf("Hosts should have independent budgets, got wait %v", wait)
+	}
+}
+
+func TestRateLimiterRedirectHops(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer target.Close()
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusFound)
+	}))
+	defer redirector.Close()
+
+	// The redirector is reached as localhost, so only the hop to 127.0.0.1 is limited.
+	limiter := NewRateLimiter(RateLimit{}).SetHostLimit("127.0.0.1", RateLimit{MinDelay: 100 * time.Millisecond})
+	c := NewTwockerClient().WithRateLimiter(limiter)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		resp, err := c.Get(strings.Replace(redirector.URL, "127.0.0.1", "localhost", 1), nil)
+		if err != nil {
+			t.Fatalf("Error making GET request: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 190*time.Millisecond {
+		t.Errorf("Expected redirect hops to be throttled, took %v", elapsed)
+	}
+}
+
+func TestRateLimiterSetHostLimitAfterRequest(t *testing.T) {
+	var current, peak atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := current.Add(1)
+		defer current.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	limiter := NewRateLimiter(RateLimit{})
+	c := NewTwockerClient().WithRateLimiter(limiter)
+	if _, err := c.Get(server.URL, nil); err != nil {
+		t.Fatalf("Error making GET request: %v", err)
+	}
+	limiter.SetHostLimit("127.0.0.1", RateLimit{MaxConcurrent: 1})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Get(server.URL, nil); err != nil {
+				t.Errorf("Error making GET request: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if peak.Load() > 1 {
+		t.Errorf("Expected the new limit to apply, saw %d concurrent requests", peak.Load())
+	}
+}
+
+func TestRateLimiterSameHostRedirect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/a", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/b", http.StatusFound)
+	})
+	mux.HandleFunc("/b", func(w http.ResponseWriter, r *http.Request) {})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c := NewTwockerClient().WithRateLimiter(NewRateLimiter(RateLimit{MaxConcurrent: 1}))
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	resp, err := c.GetContext(ctx, server.URL+"/a", nil)
+	if err != nil {
+		t.Fatalf("Error making GET request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	limiter := NewRateLimiter(RateLimit{MaxConcurrent: 1, MinDelay: 10 * time.Millisecond})
+	ctx := context.Background()
+	releaseA, err := limiter.acquire(ctx, "a.example")
+	if err != nil {
+		t.Fatalf("Error acquiring: %v", err)
+	}
+	releaseB, err := limiter.acquire(ctx, "b.example")
+	if err != nil {
+		t.Fatalf("Error acquiring: %v", err)
+	}
+	releaseB()
+
+	limiter.mu.Lock()
+	limiter.sweep(time.Now().Add(time.Second))
+	if len(limiter.hosts) != 1 || limiter.hosts["a.example"] == nil {
+		t.Errorf("Expected only the busy host to be kept, got %v", limiter.hosts)
+	}
+	limiter.mu.Unlock()
+
+	releaseA()
+	limiter.mu.Lock()
+	limiter.sweep(time.Now())
+	if len(limiter.hosts) != 1 {
+		t.Errorf("Expected a host within its minimum delay to be kept, got %v", limiter.hosts)
+	}
+	limiter.sweep(time.Now().Add(time.Second))
+	if len(limiter.hosts) != 0 {
+		t.Errorf("Expected idle hosts to be dropped, got %v", limiter.hosts)
+	}
+	limiter.mu.Unlock()
+}
+
+func TestLocalRateLimitBackendSweep(t *testing.T) {
+	b := newLocalRateLimitBackend()
+	ctx := context.Background()
+	for _, host := range []string{"a.example", "b.example"} {
+		if _, err := b.Reserve(ctx, host, time.Millisecond, 1); err != nil {
+			t.Fatalf("Error reserving: %v", err)
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	b.swept = time.Time{}
+	if _, err := b.Reserve(ctx, "c.example", time.Millisecond, 1); err != nil {
+		t.Fatalf("Error reserving: %v", err)
+	}
+	if len(b.tat) != 1 {
+		t.Errorf("Expected past reservations to be dropped, got %v", b.tat)
+	}
+}
@@ -0,0 +1,226 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Request is a fluent request builder created by TwockerClient.R. Encoding
+// errors are kept until a terminating verb is called and then returned as a
+// *RequestError matching ErrBuildRequest.
+type Request struct {
+	client      *TwockerClient
+	ctx         context.Context
+	query       url.Values
+	headers     [][2]string
+	form        url.Values
+	body        io.Reader
+	contentType string
+	err         error
+}
+
+type MultipartFile struct {
+	Field       string
+	FileName    string
+	ContentType string
+	Reader      io.Reader
+}
+
+func (c *TwockerClient) R() *Request {
+	return &Request{
+		client: c,
+		ctx:    context.Background(),
+		query:  url.Values{},
+	}
+}
+
+func (r *Request) Context(ctx context.Context) *Request {
+	r.ctx = ctx
+	return r
+}
+
+func (r *Request) Query(key string, value string) *Request {
+	r.query.Add(key, value)
+	return r
+}
+
+func (r *Request) Header(key string, value string) *Request {
+	r.headers = append(r.headers, [2]string{key, value})
+	return r
+}
+
+func (r *Request) BasicAuth(username string, password string) *Request {
+	credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	return r.Header("Authorization", "Basic "+credentials)
+}
+
+func (r *Request) BearerToken(token string) *Request {
+	return r.Header("Authorization", "Bearer "+token)
+}
+
+// Form adds a field to an application/x-www-form-urlencoded body.
+func (r *Request) Form(key string, value string) *Request {
+	if r.form == nil {
+		r.form = url.Values{}
+	}
+	r.form.Add(key, value)
+	r.body = nil
+	r.contentType = "application/x-www-form-urlencoded"
+	return r
+}
+
+func (r *Request) JSON(v any) *Request {
+	b, err := json.Marshal(v)
+	if err != nil {
+		r.err = fmt.Errorf("encode json body: %w", err)
+		return r
+	}
+	return r.Body("application/json", bytes.NewReader(b))
+}
+
+// Body sets a raw body. contentType may be empty to send no Content-Type.
+func (r *Request) Body(contentType string, body io.Reader) *Request {
+	r.form = nil
+	r.body = body
+	r.contentType = contentType
+	return r
+}
+
+// Multipart encodes fields and files as a multipart/form-data body. Fields are
+// written in key order, followed by files in the given order.
+func (r *Request) Multipart(files []MultipartFile, fields map[string]string) *Request {
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := mw.WriteField(key, fields[key]); err != nil {
+			r.err = fmt.Errorf("encode multipart field %q: %w", key, err)
+			return r
+		}
+	}
+
+	for _, file := range files {
+		contentType := file.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", multipart.FileContentDisposition(file.Field, file.FileName))
+		h.Set("Content-Type", contentType)
+		part, err := mw.CreatePart(h)
+		if err != nil {
+			r.err = fmt.Errorf("encode multipart file %q: %w", file.FileName, err)
+			return r
+		}
+		if _, err := io.Copy(part, file.Reader); err != nil {
+			r.err = fmt.Errorf("encode multipart file %q: %w", file.FileName, err)
+			return r
+		}
+	}
+	if err := mw.Close(); err != nil {
+		r.err = fmt.Errorf("encode multipart body: %w", err)
+		return r
+	}
+	return r.Body(mw.FormDataContentType(), buf)
+}
+
+func (r *Request) Get(url string) (*TwockerResponse, error) {
+	return r.Send(http.MethodGet, url)
+}
+
+func (r *Request) Post(url string) (*TwockerResponse, error) {
+	return r.Send(http.MethodPost, url)
+}
+
+func (r *Request) Put(url string) (*TwockerResponse, error) {
+	return r.Send(http.MethodPut, url)
+}
+
+func (r *Request) Patch(url string) (*TwockerResponse, error) {
+	return r.Send(http.MethodPatch, url)
+}
+
+func (r *Request) Delete(url string) (*TwockerResponse, error) {
+	return r.Send(http.MethodDelete, url)
+}
+
+func (r *Request) Send(method string, rawURL string) (*TwockerResponse, error) {
+	target, body, err := r.prepare(method, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return command(r.ctx, r.client, method, target, body, r.requestHeaders())
+}
+
+// Stream sends the request like Send but leaves the body unread.
+func (r *Request) Stream(method string, rawURL string) (*TwockerStream, error) {
+	target, body, err := r.prepare(method, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return stream(r.ctx, r.client, method, target, body, r.requestHeaders())
+}
+
+func (r *Request) prepare(method string, rawURL string) (string, io.Reader, error) {
+	if r.err != nil {
+		return "", nil, newRequestError(ErrBuildRequest, method, rawURL, nil, r.err)
+	}
+	target, err := r.url(rawURL)
+	if err != nil {
+		return "", nil, newRequestError(ErrBuildRequest, method, rawURL, nil, err)
+	}
+	body := r.body
+	if r.form != nil {
+		body = strings.NewReader(r.form.Encode())
+	}
+	return target, body, nil
+}
+
+func (r *Request) url(rawURL string) (string, error) {
+	if len(r.query) == 0 {
+		return rawURL, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	for key, values := range r.query {
+		for _, value := range values {
+			q.Add(key, value)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+func (r *Request) requestHeaders() [][2]string {
+	if r.contentType == "" || hasHeader(r.headers, "Content-Type") {
+		return r.headers
+	}
+	return append([][2]string{{"Content-Type", r.contentType}}, r.headers...)
+}
+
+func hasHeader(headers [][2]string, name string) bool {
+	for _, header := range headers {
+		if strings.EqualFold(header[0], name) {
+			return true
+		}
+	}
+	return false
+}
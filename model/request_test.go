@@ -0,0 +1,144 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type echoedRequest struct {
+	Method        string              `json:"method"`
+	Query         map[string][]string `json:"query"`
+	ContentType   string              `json:"content_type"`
+	Authorization string              `json:"authorization"`
+	Body          string              `json:"body"`
+	Form          map[string][]string `json:"form"`
+}
+
+func newEchoServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		echoed := echoedRequest{
+			Method:        r.Method,
+			Query:         r.URL.Query(),
+			ContentType:   r.Header.Get("Content-Type"),
+			Authorization: r.Header.Get("Authorization"),
+		}
+		if strings.HasPrefix(echoed.ContentType, "multipart/form-data") {
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				t.Errorf("Error parsing multipart form: %v", err)
+			}
+			echoed.Form = r.MultipartForm.Value
+			for field, files := range r.MultipartForm.File {
+				f, _ := files[0].Open()
+				b, _ := io.ReadAll(f)
+				echoed.Form[field+":"+files[0].Filename] = []string{string(b)}
+			}
+		} else {
+			b, _ := io.ReadAll(r.Body)
+			echoed.Body = string(b)
+		}
+		_ = json.NewEncoder(w).Encode(echoed)
+	}))
+}
+
+func sendAndDecode(t *testing.T, send func() (*TwockerResponse, error)) *echoedRequest {
+	t.Helper()
+	resp, err := send()
+	if err != nil {
+		t.Fatalf("Error sending request: %v", err)
+	}
+	echoed, err := TwockerJson[echoedRequest](resp)
+	if err != nil {
+		t.Fatalf("Error decoding echoed request: %v", err)
+	}
+	return echoed
+}
+
+func TestRequestBuilderQueryAndAuth(t *testing.T) {
+	server := newEchoServer(t)
+	defer server.Close()
+
+	c := NewTwockerClient()
+	echoed := sendAndDecode(t, func() (*TwockerResponse, error) {
+		return c.R().Query("q", "go lang").Query("page", "2").BasicAuth("user", "pass").Get(server.URL + "?lang=ja")
+	})
+	if echoed.Method != http.MethodGet {
+		t.Errorf("Expected GET, got %s", echoed.Method)
+	}
+	if echoed.Query["q"][0] != "go lang" || echoed.Query["page"][0] != "2" || echoed.Query["lang"][0] != "ja" {
+		t.Errorf("Unexpected query: %v", echoed.Query)
+	}
+	if echoed.Authorization != "Basic dXNlcjpwYXNz" {
+		t.Errorf("Unexpected Authorization header: %s", echoed.Authorization)
+	}
+
+	echoed = sendAndDecode(t, func() (*TwockerResponse, error) {
+		return c.R().BearerToken("token").Delete(server.URL)
+	})
+	if echoed.Authorization != "Bearer token" {
+		t.Errorf("Unexpected Authorization header: %s", echoed.Authorization)
+	}
+}
+
+func TestRequestBuilderBodies(t *testing.T) {
+	server := newEchoServer(t)
+	defer server.Close()
+
+	c := NewTwockerClient()
+	echoed := sendAndDecode(t, func() (*TwockerResponse, error) {
+		return c.R().Form("name", "John Doe").Form("age", "20").Post(server.URL)
+	})
+	if echoed.ContentType != "application/x-www-form-urlencoded" || echoed.Body != "age=20&name=John+Doe" {
+		t.Errorf("Unexpected form request: %+v", echoed)
+	}
+
+	echoed = sendAndDecode(t, func() (*TwockerResponse, error) {
+		return c.R().JSON(map[string]string{"name": "John"}).Put(server.URL)
+	})
+	if echoed.ContentType != "application/json" || echoed.Body != `{"name":"John"}` {
+		t.Errorf("Unexpected JSON request: %+v", echoed)
+	}
+
+	echoed = sendAndDecode(t, func() (*TwockerResponse, error) {
+		return c.R().JSON(map[string]string{}).Header("Content-Type", "application/vnd.api+json").Patch(server.URL)
+	})
+	if echoed.ContentType != "application/vnd.api+json" {
+		t.Errorf("Explicit Content-Type should win, got %s", echoed.ContentType)
+	}
+
+	echoed = sendAndDecode(t, func() (*TwockerResponse, error) {
+		return c.R().Multipart(
+			[]MultipartFile{{Field: "upload", FileName: "a.txt", Reader: strings.NewReader("file body")}},
+			map[string]string{"title": "hello"},
+		).Post(server.URL)
+	})
+	if !strings.HasPrefix(echoed.ContentType, "multipart/form-data; boundary=") {
+		t.Errorf("Unexpected multipart Content-Type: %s", echoed.ContentType)
+	}
+	if echoed.Form["title"][0] != "hello" || echoed.Form["upload:a.txt"][0] != "file body" {
+		t.Errorf("Unexpected multipart form: %v", echoed.Form)
+	}
+}
+
+func TestRequestBuilderJSONError(t *testing.T) {
+	_, err := NewTwockerClient().R().JSON(make(chan int)).Post("http://127.0.0.1")
+	if !errors.Is(err, ErrBuildRequest) {
+		t.Errorf("Expected ErrBuildRequest, got %v", err)
+	}
+}
+
+func TestPutSetsContentType(t *testing.T) {
+	server := newEchoServer(t)
+	defer server.Close()
+
+	echoed := sendAndDecode(t, func() (*TwockerResponse, error) {
+		return NewTwockerClient().Put(server.URL, "text/plain", strings.NewReader("hello"), nil)
+	})
+	if echoed.ContentType != "text/plain" || echoed.Body != "hello" {
+		t.Errorf("Unexpected PUT request: %+v", echoed)
+	}
+}
@@ -2,8 +2,12 @@ package model
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
+	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 
 	"github.com/PuerkitoBio/goquery"
 )
@@ -12,6 +16,23 @@ type TwockerResponse struct {
 	StatusCode int
 	body       []byte
 	url        *url.URL
+	header     http.Header
+	cookies    []*http.Cookie
+	proto      string
+	tls        *tls.ConnectionState
+	redirects  []*url.URL
+	timings    Timings
+	attempts   int
+
+	charsetOverride string
+	decodeOnce      sync.Once
+	decoded         []byte
+	charsetName     string
+	charsetCertain  bool
+
+	docOnce sync.Once
+	doc     *goquery.Document
+	docErr  error
 }
 
 func NewTwockerResponse(statusCode int, body []byte, url *url.URL) *TwockerResponse {
@@ -19,26 +40,132 @@ func NewTwockerResponse(statusCode int, body []byte, url *url.URL) *TwockerRespo
 		StatusCode: statusCode,
 		body:       body,
 		url:        url,
+		header:     http.Header{},
+		attempts:   1,
 	}
 }
 
+func newTwockerResponseFromHTTP(resp *http.Response, body []byte, timings Timings) *TwockerResponse {
+	r := NewTwockerResponse(resp.StatusCode, body, resp.Request.URL)
+	r.header = resp.Header
+	r.cookies = resp.Cookies()
+	r.proto = resp.Proto
+	r.tls = resp.TLS
+	r.redirects = redirectChain(resp)
+	r.timings = timings
+	return r
+}
+
+// redirectChain returns the URLs that were redirected away from before
+// reaching resp, oldest first.
+func redirectChain(resp *http.Response) []*url.URL {
+	var chain []*url.URL
+	for req := resp.Request; req.Response != nil; req = req.Response.Request {
+		chain = append(chain, req.Response.Request.URL)
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
+
+// TwockerJson decodes the body as JSON. Bodies are only transcoded when a BOM,
+// the Content-Type or the client declares their charset, since JSON is UTF-8
+// by default.
 func TwockerJson[T any](r *TwockerResponse) (*T, error) {
 	var v T
-	err := json.Unmarshal(r.body, &v)
+	body := r.decodedBody()
+	if !r.charsetCertain {
+		body = bytes.TrimPrefix(r.body, utf8BOM)
+	}
+	err := json.Unmarshal(body, &v)
 	if err != nil {
 		return nil, err
 	}
 	return &v, nil
 }
 
+// Document parses the body once and returns the same document on every
+// call. It is safe for concurrent use as long as the document is not
+// modified.
+func (r *TwockerResponse) Document() (*goquery.Document, error) {
+	r.docOnce.Do(func() {
+		r.doc, r.docErr = goquery.NewDocumentFromReader(bytes.NewReader(r.decodedBody()))
+		if r.doc != nil {
+			r.doc.Url = r.url
+		}
+	})
+	return r.doc, r.docErr
+}
+
 func (r *TwockerResponse) Select(selector string) (*goquery.Selection, error) {
-	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(r.body))
+	doc, err := r.Document()
 	if err != nil {
 		return nil, err
 	}
 	return doc.Find(selector), nil
 }
 
+// AbsURL resolves attr (e.g. "href" or "src") of the first element of
+// selection against the page's <base href> or URL(). It returns false when
+// the attribute is missing or cannot be made absolute.
+func (r *TwockerResponse) AbsURL(selection *goquery.Selection, attr string) (string, bool) {
+	value, ok := selection.Attr(attr)
+	if !ok {
+		return "", false
+	}
+	return r.resolve(value)
+}
+
+// AbsURLs resolves attr of every element of selection, skipping elements
+// for which AbsURL would return false.
+func (r *TwockerResponse) AbsURLs(selection *goquery.Selection, attr string) []string {
+	urls := make([]string, 0, selection.Length())
+	selection.Each(func(_ int, s *goquery.Selection) {
+		if u, ok := r.AbsURL(s, attr); ok {
+			urls = append(urls, u)
+		}
+	})
+	return urls
+}
+
+func (r *TwockerResponse) resolve(value string) (string, bool) {
+	ref, err := url.Parse(strings.TrimSpace(value))
+	if err != nil {
+		return "", false
+	}
+	base := r.baseURL()
+	if base == nil {
+		if !ref.IsAbs() {
+			return "", false
+		}
+		return ref.String(), true
+	}
+	return base.ResolveReference(ref).String(), true
+}
+
+func (r *TwockerResponse) baseURL() *url.URL {
+	doc, err := r.Document()
+	if err != nil {
+		return r.url
+	}
+	href, ok := doc.Find("base[href]").First().Attr("href")
+	if !ok {
+		return r.url
+	}
+	base, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return r.url
+	}
+	if r.url != nil {
+		return r.url.ResolveReference(base)
+	}
+	if base.IsAbs() {
+		return base
+	}
+	return nil
+}
+
 func (r *TwockerResponse) URL() *url.URL {
 	return r.url
 }
@@ -47,6 +174,60 @@ func (r *TwockerResponse) Body() []byte {
 	return r.body
 }
 
+// Text returns the body transcoded to UTF-8. Body returns the raw bytes.
 func (r *TwockerResponse) Text() string {
-	return string(r.body)
+	return string(r.decodedBody())
+}
+
+// Charset returns the name of the charset the body is decoded from.
+func (r *TwockerResponse) Charset() string {
+	r.decodedBody()
+	return r.charsetName
+}
+
+func (r *TwockerResponse) decodedBody() []byte {
+	r.decodeOnce.Do(func() {
+		e, name, certain := detectEncoding(r.body, r.ContentType(), r.charsetOverride)
+		r.decoded = decodeToUTF8(r.body, e)
+		r.charsetName = name
+		r.charsetCertain = certain
+	})
+	return r.decoded
+}
+
+func (r *TwockerResponse) Header() http.Header {
+	return r.header
+}
+
+// Cookies returns the cookies set by the final response's Set-Cookie headers.
+func (r *TwockerResponse) Cookies() []*http.Cookie {
+	return r.cookies
+}
+
+func (r *TwockerResponse) ContentType() string {
+	return r.header.Get("Content-Type")
+}
+
+func (r *TwockerResponse) Proto() string {
+	return r.proto
+}
+
+// TLS returns the connection state of the final response, or nil for plain
+// HTTP.
+func (r *TwockerResponse) TLS() *tls.ConnectionState {
+	return r.tls
+}
+
+// RedirectChain returns the URLs that redirected to URL(), oldest first.
+func (r *TwockerResponse) RedirectChain() []*url.URL {
+	return r.redirects
+}
+
+func (r *TwockerResponse) Timings() Timings {
+	return r.timings
+}
+
+// Attempts returns how many attempts the client's retry policy made.
+func (r *TwockerResponse) Attempts() int {
+	return r.attempts
 }
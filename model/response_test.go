@@ -1,7 +1,17 @@
 package model
 
 import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
 	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
 )
 
 func TestJson(t *testing.T) {
@@ -28,3 +38,255 @@ func TestSelect(t *testing.T) {
 		t.Errorf("Expected text Hello, got %s", selection.Text())
 	}
 }
+
+func TestResponseMetadata(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/start", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/middle", http.StatusFound)
+	})
+	mux.HandleFunc("/middle", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/final", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/final", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("X-Test", "yes")
+		_, _ = w.Write([]byte("<p>ok</p>"))
+	})
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+
+	c := NewTwockerClient()
+	c.Client = server.Client()
+	resp, err := c.Get(server.URL+"/start", nil)
+	if err != nil {
+		t.Fatalf("Error making GET request: %v", err)
+	}
+	if resp.Header().Get("X-Test") != "yes" {
+		t.Errorf("Expected X-Test header, got %v", resp.Header())
+	}
+	if resp.ContentType() != "text/html; charset=utf-8" {
+		t.Errorf("Unexpected content type %s", resp.ContentType())
+	}
+	if cookies := resp.Cookies(); len(cookies) != 1 || cookies[0].Name != "session" || cookies[0].Value != "abc" {
+		t.Errorf("Unexpected cookies %v", cookies)
+	}
+	if resp.Proto() != "HTTP/1.1" {
+		t.Errorf("Unexpected proto %s", resp.Proto())
+	}
+	if resp.TLS() == nil {
+		t.Errorf("Expected TLS connection state")
+	}
+	chain := resp.RedirectChain()
+	if len(chain) != 2 || chain[0].Path != "/start" || chain[1].Path != "/middle" {
+		t.Errorf("Unexpected redirect chain %v", chain)
+	}
+	if resp.URL().Path != "/final" {
+		t.Errorf("Unexpected final URL %s", resp.URL())
+	}
+	timings := resp.Timings()
+	if timings.Connect <= 0 || timings.TLSHandshake <= 0 || timings.TTFB <= 0 {
+		t.Errorf("Expected connection timings to be recorded, got %+v", timings)
+	}
+	if timings.Total < timings.TTFB {
+		t.Errorf("Total %v should not be shorter than TTFB %v", timings.Total, timings.TTFB)
+	}
+}
+
+func encodeForTest(t *testing.T, e encoding.Encoding, s string) []byte {
+	t.Helper()
+	b, err := e.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		t.Fatalf("Error encoding test data: %v", err)
+	}
+	return b
+}
+
+func newTestResponse(body []byte, contentType string) *TwockerResponse {
+	r := NewTwockerResponse(200, body, nil)
+	if contentType != "" {
+		r.header.Set("Content-Type", contentType)
+	}
+	return r
+}
+
+func TestCharsetDetection(t *testing.T) {
+	const text = "こんにちは、世界"
+	cases := []struct {
+		name     string
+		response *TwockerResponse
+		charset  string
+	}{
+		{
+			name:     "content type",
+			response: newTestResponse(encodeForTest(t, japanese.ShiftJIS, "<p class=\"test\">"+text+"</p>"), "text/html; charset=Shift_JIS"),
+			charset:  "shift_jis",
+		},
+		{
+			name:     "meta charset",
+			response: newTestResponse(encodeForTest(t, japanese.EUCJP, `<html><head><meta charset="EUC-JP"></head><body><p class="test">`+text+`</p></body></html>`), "text/html"),
+			charset:  "euc-jp",
+		},
+		{
+			name:     "meta http-equiv",
+			response: newTestResponse(encodeForTest(t, japanese.ShiftJIS, `<html><head><meta http-equiv="Content-Type" content="text/html; charset=shift_jis"></head><body><p class="test">`+text+`</p></body></html>`), ""),
+			charset:  "shift_jis",
+		},
+		{
+			name:     "utf-8 bom",
+			response: newTestResponse(append([]byte("\xEF\xBB\xBF"), []byte(`<p class="test">`+text+`</p>`)...), ""),
+			charset:  "utf-8",
+		},
+	}
+	for _, tc := range cases {
+		if tc.response.Charset() != tc.charset {
+			t.Errorf("%s: expected charset %s, got %s", tc.name, tc.charset, tc.response.Charset())
+		}
+		if !strings.Contains(tc.response.Text(), text) {
+			t.Errorf("%s: expected decoded text to contain %q, got %q", tc.name, text, tc.response.Text())
+		}
+		selection, err := tc.response.Select(".test")
+		if err != nil {
+			t.Errorf("%s: error selecting element: %v", tc.name, err)
+		} else if selection.Text() != text {
+			t.Errorf("%s: expected selected text %q, got %q", tc.name, text, selection.Text())
+		}
+	}
+
+	// Nothing declares a charset and the first 1024 bytes are ASCII
+	padding := strings.Repeat("a", 1100)
+	late := newTestResponse([]byte(`<html><body><p class="test">`+padding+text+`</p></body></html>`), "text/html")
+	if late.Charset() != "utf-8" || !strings.Contains(late.Text(), padding+text) {
+		t.Errorf("Expected undeclared UTF-8 to be kept, got %s", late.Charset())
+	}
+	lateJSON := newTestResponse([]byte(`{"padding":"`+padding+`","name":"café"}`), "application/json")
+	if !strings.Contains(lateJSON.Text(), "café") {
+		t.Errorf("Expected undeclared UTF-8 JSON to be kept, got %s", lateJSON.Charset())
+	}
+
+	latin1 := newTestResponse(encodeForTest(t, charmap.Windows1252, `<meta charset="windows-1252"><p>café</p>`), "")
+	if !strings.Contains(latin1.Text(), "café") {
+		t.Errorf("Expected windows-1252 text to be decoded, got %q", latin1.Text())
+	}
+
+	// Declared charsets win over bytes that happen to be valid UTF-8
+	declared := newTestResponse([]byte("<meta charset=\"windows-1252\"><p>caf\xC3\xA9</p>"), "")
+	if declared.Charset() != "windows-1252" || !strings.Contains(declared.Text(), "cafÃ©") {
+		t.Errorf("Expected declared windows-1252 to be kept, got %s %q", declared.Charset(), declared.Text())
+	}
+	eucJP := newTestResponse([]byte("<meta charset=\"EUC-JP\"><p>\xC3\xA9</p>"), "")
+	if eucJP.Charset() != "euc-jp" {
+		t.Errorf("Expected declared EUC-JP to be kept, got %s", eucJP.Charset())
+	}
+}
+
+func TestCharsetOverride(t *testing.T) {
+	body := encodeForTest(t, japanese.ShiftJIS, "日本語")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, _ = w.Write(body)
+	}))
+	defer server.Close()
+
+	resp, err := NewTwockerClient().WithCharset("sjis").Get(server.URL, nil)
+	if err != nil {
+		t.Fatalf("Error making GET request: %v", err)
+	}
+	if resp.Text() != "日本語" || resp.Charset() != "shift_jis" {
+		t.Errorf("Expected override to decode Shift_JIS, got %q (%s)", resp.Text(), resp.Charset())
+	}
+	if !bytes.Equal(resp.Body(), body) {
+		t.Errorf("Body should keep the raw bytes")
+	}
+}
+
+func TestJsonCharset(t *testing.T) {
+	type User struct {
+		Name string `json:"name"`
+	}
+	response := newTestResponse(encodeForTest(t, japanese.ShiftJIS, `{"name":"山田"}`), "application/json; charset=shift_jis")
+	user, err := TwockerJson[User](response)
+	if err != nil {
+		t.Fatalf("Error unmarshalling JSON: %v", err)
+	}
+	if user.Name != "山田" {
+		t.Errorf("Expected name 山田, got %s", user.Name)
+	}
+
+	response = newTestResponse([]byte("\xEF\xBB\xBF"+`{"name":"John"}`), "application/json")
+	user, err = TwockerJson[User](response)
+	if err != nil || user.Name != "John" {
+		t.Errorf("Expected JSON with a BOM to decode, got %v, %v", user, err)
+	}
+}
+
+func TestDocumentIsCached(t *testing.T) {
+	response := NewTwockerResponse(200, []byte(`<ul><li>a</li><li>b</li><li>c</li></ul>`), nil)
+	first, err := response.Document()
+	if err != nil {
+		t.Fatalf("Error parsing document: %v", err)
+	}
+	second, _ := response.Document()
+	if first != second {
+		t.Errorf("Expected Document to return the cached document")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			selection, err := response.Select("li")
+			if err != nil || selection.Length() != 3 {
+				t.Errorf("Expected 3 items, got %d (%v)", selection.Length(), err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestAbsURL(t *testing.T) {
+	pageURL, _ := url.Parse("https://example.com/articles/page.html")
+	body := []byte(`<a id="rel" href="next.html">next</a>
+<a id="root" href="/about">about</a>
+<a id="abs" href="https://other.example/x">x</a>
+<img src="../img/logo.png">
+<a id="none">none</a>`)
+
+	response := NewTwockerResponse(200, body, pageURL)
+	cases := map[string]string{
+		"#rel":  "https://example.com/articles/next.html",
+		"#root": "https://example.com/about",
+		"#abs":  "https://other.example/x",
+	}
+	for selector, want := range cases {
+		selection, _ := response.Select(selector)
+		if got, ok := response.AbsURL(selection, "href"); !ok || got != want {
+			t.Errorf("AbsURL(%s) = %q, %v; want %q", selector, got, ok, want)
+		}
+	}
+	img, _ := response.Select("img")
+	if got, _ := response.AbsURL(img, "src"); got != "https://example.com/img/logo.png" {
+		t.Errorf("Unexpected image URL %q", got)
+	}
+	none, _ := response.Select("#none")
+	if _, ok := response.AbsURL(none, "href"); ok {
+		t.Errorf("Expected missing attribute to return false")
+	}
+	links, _ := response.Select("a")
+	if got := response.AbsURLs(links, "href"); len(got) != 3 {
+		t.Errorf("Expected 3 resolved links, got %v", got)
+	}
+
+	withBase := NewTwockerResponse(200, []byte(`<head><base href="/static/"></head><a href="app.js">app</a>`), pageURL)
+	link, _ := withBase.Select("a")
+	if got, _ := withBase.AbsURL(link, "href"); got != "https://example.com/static/app.js" {
+		t.Errorf("Expected <base href> to be honoured, got %q", got)
+	}
+
+	noURL := NewTwockerResponse(200, []byte(`<a href="relative">r</a>`), nil)
+	link, _ = noURL.Select("a")
+	if _, ok := noURL.AbsURL(link, "href"); ok {
+		t.Errorf("Expected relative URL without a page URL to return false")
+	}
+}
@@ -0,0 +1,151 @@
+package model
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how TwockerClient retries failed attempts. Attempts
+// are retried on the configured status codes and on transport errors and
+// timeouts; TLS and request-build errors are never retried. As the server
+// may have processed a request that failed in transit, transport errors and
+// timeouts are only retried for idempotent methods and for requests with an
+// Idempotency-Key header.
+//
+// A request body is only replayed when it can be rewound (http.NewRequest
+// sets GetBody for bytes and strings readers, and so does every builder
+// body). Other bodies are buffered for idempotent methods and sent once for
+// the rest.
+type RetryPolicy struct {
+	// MaxAttempts includes the first attempt. Values below 2 disable retries.
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter randomizes each backoff by up to ±Jitter of its value (0 to 1).
+	Jitter           float64
+	RetryStatusCodes []int
+	// ShouldRetry, when set, replaces the status code and error checks.
+	// Exactly one of resp and err is non-nil.
+	ShouldRetry func(resp *http.Response, err error) bool
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:      3,
+		InitialBackoff:   500 * time.Millisecond,
+		MaxBackoff:       30 * time.Second,
+		Multiplier:       2,
+		Jitter:           0.2,
+		RetryStatusCodes: []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+	}
+}
+
+func (c *TwockerClient) WithRetryPolicy(policy *RetryPolicy) *TwockerClient {
+	c.retryPolicy = policy
+	return c
+}
+
+func (p *RetryPolicy) enabled() bool {
+	return p != nil && p.MaxAttempts > 1
+}
+
+func (p *RetryPolicy) shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if p.ShouldRetry != nil {
+		return p.ShouldRetry(resp, err)
+	}
+	if err != nil {
+		if !isIdempotent(req.Method) && req.Header.Get("Idempotency-Key") == "" {
+			return false
+		}
+		return errors.Is(err, ErrTransport) || errors.Is(err, ErrTimeout)
+	}
+	return slices.Contains(p.RetryStatusCodes, resp.StatusCode)
+}
+
+// backoff returns how long to wait before the attempt following attempt.
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	d := float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt-1))
+	if p.Jitter > 0 {
+		d *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	return time.Duration(d)
+}
+
+// wait returns the delay before the next attempt, and false when the
+// server's Retry-After asks for longer than MaxBackoff.
+func (p *RetryPolicy) wait(attempt int, resp *http.Response) (time.Duration, bool) {
+	d := p.backoff(attempt)
+	if resp == nil {
+		return d, true
+	}
+	retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		return d, true
+	}
+	if p.MaxBackoff > 0 && retryAfter > p.MaxBackoff {
+		return 0, false
+	}
+	return max(d, retryAfter), true
+}
+
+// parseRetryAfter accepts both the delay-seconds and HTTP-date forms.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	return max(at.Sub(now), 0), true
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// prepareReplay reports whether req's body can be sent more than once,
+// buffering it in memory for idempotent methods when necessary.
+func prepareReplay(req *http.Request) (bool, error) {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return true, nil
+	}
+	if !isIdempotent(req.Method) {
+		return false, nil
+	}
+	b, err := io.ReadAll(req.Body)
+	if err != nil {
+		return false, err
+	}
+	_ = req.Body.Close()
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(b)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return true, nil
+}
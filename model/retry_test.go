@@ -0,0 +1,187 @@
+package model
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func fastRetryPolicy() *RetryPolicy {
+	policy := DefaultRetryPolicy()
+	policy.InitialBackoff = time.Millisecond
+	policy.MaxBackoff = 50 * time.Millisecond
+	policy.Jitter = 0
+	return policy
+}
+
+func TestRetryOnStatusCode(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	resp, err := NewTwockerClient().WithRetryPolicy(fastRetryPolicy()).Get(server.URL, nil)
+	if err != nil {
+		t.Fatalf("Error making GET request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Text() != "ok" {
+		t.Errorf("Expected final 200 ok, got %d %q", resp.StatusCode, resp.Text())
+	}
+	if resp.Attempts() != 3 {
+		t.Errorf("Expected 3 attempts, got %d", resp.Attempts())
+	}
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	resp, err := NewTwockerClient().WithRetryPolicy(fastRetryPolicy()).Get(server.URL, nil)
+	if err != nil {
+		t.Fatalf("Error making GET request: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadGateway || resp.Attempts() != 3 || calls.Load() != 3 {
+		t.Errorf("Expected 3 attempts ending in 502, got %d after %d attempts", resp.StatusCode, resp.Attempts())
+	}
+}
+
+func TestRetryHonoursRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	policy := fastRetryPolicy()
+	policy.MaxBackoff = 2 * time.Second
+	start := time.Now()
+	resp, err := NewTwockerClient().WithRetryPolicy(policy).Get(server.URL, nil)
+	if err != nil {
+		t.Fatalf("Error making GET request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200, got %d", resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Expected to wait for Retry-After, waited %v", elapsed)
+	}
+
+	calls.Store(0)
+	policy.MaxBackoff = 100 * time.Millisecond
+	resp, err = NewTwockerClient().WithRetryPolicy(policy).Get(server.URL, nil)
+	if err != nil {
+		t.Fatalf("Error making GET request: %v", err)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Attempts() != 1 {
+		t.Errorf("Retry-After beyond MaxBackoff should not be retried, got %d after %d attempts", resp.StatusCode, resp.Attempts())
+	}
+}
+
+func TestRetryReplaysBodies(t *testing.T) {
+	var calls atomic.Int32
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if calls.Add(1)%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c := NewTwockerClient().WithRetryPolicy(fastRetryPolicy())
+	resp, err := c.R().JSON(map[string]int{"n": 1}).Post(server.URL)
+	if err != nil {
+		t.Fatalf("Error making POST request: %v", err)
+	}
+	if resp.Attempts() != 2 || bodies[0] != `{"n":1}` || bodies[1] != `{"n":1}` {
+		t.Errorf("Expected rewindable POST body to be replayed, got %v after %d attempts", bodies, resp.Attempts())
+	}
+
+	bodies = nil
+	resp, err = c.Put(server.URL, "text/plain", io.MultiReader(strings.NewReader("put")), nil)
+	if err != nil {
+		t.Fatalf("Error making PUT request: %v", err)
+	}
+	if resp.Attempts() != 2 || bodies[0] != "put" || bodies[1] != "put" {
+		t.Errorf("Expected idempotent PUT body to be buffered and replayed, got %v after %d attempts", bodies, resp.Attempts())
+	}
+
+	bodies = nil
+	resp, err = c.Post(server.URL, io.MultiReader(strings.NewReader("post")), nil)
+	if err != nil {
+		t.Fatalf("Error making POST request: %v", err)
+	}
+	if resp.Attempts() != 1 || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected non-rewindable POST not to be retried, got %d after %d attempts", resp.StatusCode, resp.Attempts())
+	}
+}
+
+func TestRetryOnTransportError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serverURL := server.URL
+	server.Close()
+
+	_, err := NewTwockerClient().WithRetryPolicy(fastRetryPolicy()).Get(serverURL, nil)
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) || !errors.Is(err, ErrTransport) {
+		t.Fatalf("Expected transport RequestError, got %v", err)
+	}
+	if reqErr.Attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", reqErr.Attempts)
+	}
+
+	// The server may have processed a POST that failed in transit.
+	c := NewTwockerClient().WithRetryPolicy(fastRetryPolicy())
+	_, err = c.R().JSON(map[string]int{"n": 1}).Post(serverURL)
+	if !errors.As(err, &reqErr) || reqErr.Attempts != 1 {
+		t.Errorf("Expected POST not to be retried after a transport error, got %v", err)
+	}
+	_, err = c.R().Header("Idempotency-Key", "k1").JSON(map[string]int{"n": 1}).Post(serverURL)
+	if !errors.As(err, &reqErr) || reqErr.Attempts != 3 {
+		t.Errorf("Expected POST with an Idempotency-Key to be retried, got %v", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"-1", 0, false},
+		{"Mon, 01 Jan 2024 00:00:30 GMT", 30 * time.Second, true},
+		{"Sun, 31 Dec 2023 23:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+	for _, tc := range cases {
+		got, ok := parseRetryAfter(tc.value, now)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v; want %v, %v", tc.value, got, ok, tc.want, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	robotsCacheTTL       = 24 * time.Hour
+	robotsUnreachableTTL = time.Minute
+	// robotsMaxSize is the minimum parsing limit required by RFC 9309.
+	robotsMaxSize = 500 << 10
+)
+
+// robotsCache fetches and caches /robots.txt per scheme and host, following
+// RFC 9309: a missing file (4xx) allows everything and an unreachable one
+// (5xx or network error) disallows everything until it is fetched again.
+type robotsCache struct {
+	mu        sync.Mutex
+	userAgent string
+	entries   map[string]*robotsEntry
+}
+
+// robotsFetchKey marks the context of robots.txt fetches, whose redirects
+// are not themselves checked against robots.txt.
+type robotsFetchKey struct{}
+
+type robotsEntry struct {
+	ready   chan struct{}
+	group   *robotsGroup
+	expires time.Time
+	err     error
+}
+
+// WithRobots makes the client fetch /robots.txt for every host it talks to
+// and refuse requests and redirects that userAgent is disallowed from with
+// an error matching ErrRobotsDisallowed. Crawl-delay values raise the minimum delay
+// of the client's rate limiter, which is created if the client has none.
+func (c *TwockerClient) WithRobots(userAgent string) *TwockerClient {
+	c.robots = &robotsCache{
+		userAgent: userAgent,
+		entries:   make(map[string]*robotsEntry),
+	}
+	if c.rateLimiter == nil {
+		c.rateLimiter = NewRateLimiter(RateLimit{})
+	}
+	return c
+}
+
+// check returns a *RequestError when req may not be sent.
+func (r *robotsCache) check(c *TwockerClient, req *http.Request) error {
+	if req.URL.Path == "/robots.txt" {
+		return nil
+	}
+	group, err := r.lookup(req.Context(), c, req.URL)
+	if err != nil {
+		return newRequestError(classifyTransportError(err), req.Method, "", req, err)
+	}
+	if group == nil {
+		return nil
+	}
+	if group.crawlDelay > 0 && c.rateLimiter != nil {
+		c.rateLimiter.setCrawlDelay(req.URL.Hostname(), group.crawlDelay)
+	}
+	if !group.allowed(req.URL.EscapedPath(), req.URL.RawQuery) {
+		return newRequestError(ErrRobotsDisallowed, req.Method, "", req, errors.New("disallowed for "+r.userAgent))
+	}
+	return nil
+}
+
+// lookup returns the rules for u, fetching them unless they are cached or
+// being fetched. Waiters whose fetcher gave up retry with their own context.
+func (r *robotsCache) lookup(ctx context.Context, c *TwockerClient, u *url.URL) (*robotsGroup, error) {
+	key := u.Scheme + "://" + u.Host
+	for {
+		r.mu.Lock()
+		entry, ok := r.entries[key]
+		if ok {
+			select {
+			case <-entry.ready:
+				ok = time.Now().Before(entry.expires)
+			default:
+			}
+		}
+		if !ok {
+			entry = &robotsEntry{ready: make(chan struct{})}
+			r.entries[key] = entry
+			r.mu.Unlock()
+			return r.load(ctx, c, u, key, entry)
+		}
+		r.mu.Unlock()
+
+		select {
+		case <-entry.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if entry.err == nil {
+			return entry.group, nil
+		}
+	}
+}
+
+// load fetches the rules into entry, which it has registered under key.
+func (r *robotsCache) load(ctx context.Context, c *TwockerClient, u *url.URL, key string, entry *robotsEntry) (*robotsGroup, error) {
+	entry.group, entry.expires, entry.err = r.fetch(ctx, c, u)
+	if entry.err != nil {
+		// Do not cache a fetch cut short by its caller's context.
+		r.mu.Lock()
+		if r.entries[key] == entry {
+			delete(r.entries, key)
+		}
+		r.mu.Unlock()
+	}
+	close(entry.ready)
+	return entry.group, entry.err
+}
+
+// fetch downloads and parses robots.txt. It only returns an error when ctx
+// ends; every other failure is turned into a rule set.
+func (r *robotsCache) fetch(ctx context.Context, c *TwockerClient, u *url.URL) (*robotsGroup, time.Time, error) {
+	robotsURL := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/robots.txt"}
+	req, err := http.NewRequestWithContext(context.WithValue(ctx, robotsFetchKey{}, true), http.MethodGet, robotsURL.String(), nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if r.userAgent != "" {
+		req.Header.Set("User-Agent", r.userAgent)
+	}
+	resp, err := c.httpClient(ctx).Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, time.Time{}, ctx.Err()
+		}
+		return disallowAll, time.Now().Add(robotsUnreachableTTL), nil
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500:
+		return disallowAll, time.Now().Add(robotsUnreachableTTL), nil
+	case resp.StatusCode >= 400:
+		return nil, time.Now().Add(robotsCacheTTL), nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, robotsMaxSize))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, time.Time{}, ctx.Err()
+		}
+		return disallowAll, time.Now().Add(robotsUnreachableTTL), nil
+	}
+	return parseRobots(body).group(r.userAgent), time.Now().Add(robotsCacheTTL), nil
+}
+
+type robotsTxt struct {
+	groups []*robotsGroup
+}
+
+type robotsGroup struct {
+	agents     []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+var disallowAll = &robotsGroup{rules: []robotsRule{{allow: false, pattern: "/"}}}
+
+func parseRobots(body []byte) *robotsTxt {
+	robots := &robotsTxt{}
+	var group *robotsGroup
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64<<10), robotsMaxSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// Consecutive user-agent lines share one group.
+			if group == nil || len(group.rules) > 0 || group.crawlDelay > 0 {
+				group = &robotsGroup{}
+				robots.groups = append(robots.groups, group)
+			}
+			group.agents = append(group.agents, strings.ToLower(value))
+		case "allow", "disallow":
+			if group == nil || value == "" {
+				continue
+			}
+			group.rules = append(group.rules, robotsRule{allow: key == "allow", pattern: value})
+		case "crawl-delay":
+			if group == nil {
+				continue
+			}
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+				group.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+		}
+	}
+	return robots
+}
+
+// group merges every group naming the product token of userAgent, falling
+// back to the groups for "*". It returns nil when no group applies.
+func (r *robotsTxt) group(userAgent string) *robotsGroup {
+	token, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(userAgent)), "/")
+	token = strings.TrimSpace(token)
+
+	merge := func(agent string) *robotsGroup {
+		var merged *robotsGroup
+		for _, group := range r.groups {
+			for _, a := range group.agents {
+				if a != agent {
+					continue
+				}
+				if merged == nil {
+					merged = &robotsGroup{agents: []string{agent}}
+				}
+				merged.rules = append(merged.rules, group.rules...)
+				merged.crawlDelay = max(merged.crawlDelay, group.crawlDelay)
+				break
+			}
+		}
+		return merged
+	}
+	if token != "" && token != "*" {
+		if merged := merge(token); merged != nil {
+			return merged
+		}
+	}
+	return merge("*")
+}
+
+// allowed applies the longest matching rule, preferring allow rules on ties.
+func (g *robotsGroup) allowed(path string, rawQuery string) bool {
+	if path == "" {
+		path = "/"
+	}
+	if rawQuery != "" {
+		path += "?" + rawQuery
+	}
+	allowed := true
+	matched := -1
+	for _, rule := range g.rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		if len(rule.pattern) > matched || (len(rule.pattern) == matched && rule.allow) {
+			matched = len(rule.pattern)
+			allowed = rule.allow
+		}
+	}
+	return allowed
+}
+
+// robotsMatch matches path against a robots.txt pattern, where "*" matches
+// any sequence of characters and a trailing "$" anchors the end of the path.
+func robotsMatch(pattern string, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = pattern[:len(pattern)-1]
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	for i, part := range parts[1:] {
+		if anchored && i == len(parts)-2 {
+			return strings.HasSuffix(rest, part)
+		}
+		idx := strings.Index(rest, part)
+		if idx < 0 {
+			return false
+		}
+		rest = rest[idx+len(part):]
+	}
+	return !anchored || rest == ""
+}
@@ -0,0 +1,230 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testRobotsTxt = `
+# comment
+User-agent: *
+Disallow: /private
+Allow: /private/public
+Disallow: /*.pdf$
+Disallow: /search?*q=
+
+User-agent: TwockerBot
+User-agent: OtherBot
+Disallow: /bots-only
+Crawl-delay: 0.05
+
+User-agent: twockerbot
+Disallow: /more
+`
+
+func TestRobotsGroupSelection(t *testing.T) {
+	robots := parseRobots([]byte(testRobotsTxt))
+
+	generic := robots.group("Mozilla/5.0")
+	specific := robots.group("TwockerBot/1.0 (+https://example.com)")
+	cases := []struct {
+		group *robotsGroup
+		path  string
+		query string
+		want  bool
+	}{
+		{generic, "/", "", true},
+		{generic, "/private/secret", "", false},
+		{generic, "/private/public/page", "", true},
+		{generic, "/files/report.pdf", "", false},
+		{generic, "/files/report.pdf.html", "", true},
+		{generic, "/search", "lang=ja&q=go", false},
+		{generic, "/search", "lang=ja", true},
+		{generic, "/bots-only", "", true},
+		{specific, "/private/secret", "", true},
+		{specific, "/bots-only/page", "", false},
+		{specific, "/more", "", false},
+	}
+	for _, tc := range cases {
+		if got := tc.group.allowed(tc.path, tc.query); got != tc.want {
+			t.Errorf("allowed(%q, %q) for %v = %v, want %v", tc.path, tc.query, tc.group.agents, got, tc.want)
+		}
+	}
+	if specific.crawlDelay != 50*time.Millisecond {
+		t.Errorf("Expected crawl delay 50ms, got %v", specific.crawlDelay)
+	}
+	if (&robotsTxt{}).group("TwockerBot") != nil {
+		t.Errorf("Expected no group for an empty robots.txt")
+	}
+}
+
+func TestRobotsMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/", "/anything", true},
+		{"/fish", "/fish.html", true},
+		{"/fish", "/Fish", false},
+		{"/fish*", "/fishheads", true},
+		{"/*.php", "/folder/index.php?x=1", true},
+		{"/*.php$", "/folder/index.php?x=1", false},
+		{"/*.php$", "/folder/index.php", true},
+		{"/a*b*c$", "/axxbyyc", true},
+		{"/a*b*c$", "/axxbyycd", false},
+		{"/exact$", "/exact", true},
+		{"/exact$", "/exactly", false},
+	}
+	for _, tc := range cases {
+		if got := robotsMatch(tc.pattern, tc.path); got != tc.want {
+			t.Errorf("robotsMatch(%q, %q) = %v, want %v", tc.pattern, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestClientWithRobots(t *testing.T) {
+	var robotsFetches, pageFetches atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		robotsFetches.Add(1)
+		if r.Header.Get("User-Agent") != "TwockerBot/1.0" {
+			t.Errorf("Expected robots.txt to be fetched with our user agent, got %q", r.Header.Get("User-Agent"))
+		}
+		_, _ = w.Write([]byte(testRobotsTxt))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		pageFetches.Add(1)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c := NewTwockerClient().WithRobots("TwockerBot/1.0")
+	_, err := c.Get(server.URL+"/bots-only/page", nil)
+	if !errors.Is(err, ErrRobotsDisallowed) {
+		t.Fatalf("Expected ErrRobotsDisallowed, got %v", err)
+	}
+	if pageFetches.Load() != 0 {
+		t.Errorf("Disallowed request should not reach the server")
+	}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(server.URL+"/allowed", nil); err != nil {
+			t.Fatalf("Error making GET request: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected Crawl-delay to space out requests, took %v", elapsed)
+	}
+	if robotsFetches.Load() != 1 {
+		t.Errorf("Expected robots.txt to be fetched once, got %d", robotsFetches.Load())
+	}
+}
+
+func TestClientWithRobotsRedirect(t *testing.T) {
+	var disallowedFetches atomic.Int32
+	mux := http.NewServeMux()
+	// robots.txt itself may redirect on the same host.
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/robots-moved.txt", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/robots-moved.txt", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(testRobotsTxt))
+	})
+	mux.HandleFunc("/public", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/private", http.StatusFound)
+	})
+	mux.HandleFunc("/private", func(w http.ResponseWriter, r *http.Request) {
+		disallowedFetches.Add(1)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	_, err := NewTwockerClient().WithRobots("Mozilla/5.0").GetContext(ctx, server.URL+"/public", nil)
+	if !errors.Is(err, ErrRobotsDisallowed) {
+		t.Fatalf("Expected ErrRobotsDisallowed, got %v", err)
+	}
+	if disallowedFetches.Load() != 0 {
+		t.Errorf("Disallowed redirect should not reach the server")
+	}
+}
+
+func TestClientWithRobotsStatusHandling(t *testing.T) {
+	for _, tc := range []struct {
+		status  int
+		allowed bool
+	}{
+		{http.StatusNotFound, true},
+		{http.StatusServiceUnavailable, false},
+	} {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tc.status)
+		})
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+		server := httptest.NewServer(mux)
+
+		_, err := NewTwockerClient().WithRobots("TwockerBot").Get(server.URL+"/page", nil)
+		if tc.allowed && err != nil {
+			t.Errorf("robots.txt status %d: expected request to be allowed, got %v", tc.status, err)
+		}
+		if !tc.allowed && !errors.Is(err, ErrRobotsDisallowed) {
+			t.Errorf("robots.txt status %d: expected ErrRobotsDisallowed, got %v", tc.status, err)
+		}
+		server.Close()
+	}
+}
+
+func TestRobotsLookupCancelledFetcher(t *testing.T) {
+	var fetches atomic.Int32
+	started := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) == 1 {
+			close(started)
+			<-r.Context().Done()
+			return
+		}
+		_, _ = w.Write([]byte(testRobotsTxt))
+	}))
+	defer server.Close()
+
+	c := NewTwockerClient().WithRobots("TwockerBot/1.0")
+	u, _ := url.Parse(server.URL + "/page")
+	ctx, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := c.robots.lookup(ctx, c, u)
+		firstErr <- err
+	}()
+	<-started
+
+	// The second lookup waits on the first one's fetch, which is cancelled.
+	secondErr := make(chan error, 1)
+	var group *robotsGroup
+	go func() {
+		var err error
+		group, err = c.robots.lookup(context.Background(), c, u)
+		secondErr <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the cancelled lookup to fail with its context error, got %v", err)
+	}
+	if err := <-secondErr; err != nil {
+		t.Fatalf("Expected the live lookup to fetch robots.txt itself, got %v", err)
+	}
+	if group == nil || group.allowed("/bots-only", "") {
+		t.Errorf("Expected the live lookup to get the rules of robots.txt")
+	}
+}
@@ -0,0 +1,341 @@
+package model
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrBodyTooLarge     = errors.New("twocker: response body exceeds the size limit")
+	ErrChecksumMismatch = errors.New("twocker: checksum mismatch")
+)
+
+// TwockerStream is a response whose body is read by the caller instead of
+// being buffered. It must be closed, either directly or by SaveToFile.
+type TwockerStream struct {
+	StatusCode int
+	// ContentLength is -1 when the length is unknown.
+	ContentLength int64
+	Body          io.ReadCloser
+	header        http.Header
+	url           *url.URL
+	attempts      int
+}
+
+// DownloadOptions configures SaveToFile and Download. Every field is optional.
+type DownloadOptions struct {
+	// MaxBodySize aborts the download with ErrBodyTooLarge once more bytes
+	// than this have been received.
+	MaxBodySize int64
+	// Progress is called after every write with the bytes written so far and
+	// the expected total, which is -1 when unknown.
+	Progress func(written int64, total int64)
+	// Hash and Checksum verify the downloaded file against a hex-encoded
+	// digest, failing with ErrChecksumMismatch.
+	Hash     func() hash.Hash
+	Checksum string
+}
+
+func (c *TwockerClient) GetStream(ctx context.Context, url string, headers [][2]string) (*TwockerStream, error) {
+	return stream(ctx, c, http.MethodGet, url, nil, headers)
+}
+
+func stream(ctx context.Context, c *TwockerClient, method string, url string, body io.Reader, headers [][2]string) (*TwockerStream, error) {
+	req, err := newRequest(ctx, method, url, body, headers)
+	if err != nil {
+		return nil, err
+	}
+	resp, _, attempts, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	return &TwockerStream{
+		StatusCode:    resp.StatusCode,
+		ContentLength: resp.ContentLength,
+		Body:          resp.Body,
+		header:        resp.Header,
+		url:           resp.Request.URL,
+		attempts:      attempts,
+	}, nil
+}
+
+func (s *TwockerStream) Header() http.Header {
+	return s.header
+}
+
+func (s *TwockerStream) URL() *url.URL {
+	return s.url
+}
+
+func (s *TwockerStream) Attempts() int {
+	return s.attempts
+}
+
+func (s *TwockerStream) Close() error {
+	return s.Body.Close()
+}
+
+// SaveToFile writes the body to a temporary file next to path and renames
+// it into place once it is complete, so path never holds a partial file. The
+// stream is closed when SaveToFile returns.
+func (s *TwockerStream) SaveToFile(path string, opts *DownloadOptions) error {
+	defer s.Close()
+	if opts == nil {
+		opts = &DownloadOptions{}
+	}
+
+	tmp, err := createTemp(path)
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if err := writeDownload(tmp, s.Body, 0, s.ContentLength, opts.newHash(), opts); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	if err := finishFile(tmp, tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// Download saves url to path, resuming from path + ".part" with a Range
+// request when an earlier download was interrupted. The ETag or
+// Last-Modified of the response is kept in path + ".part.validator" and sent
+// as If-Range, so a resource that changed in between is downloaded again
+// from the start. The partial file is kept on transport errors and removed
+// when the size limit or checksum fails.
+func (c *TwockerClient) Download(ctx context.Context, url string, path string, headers [][2]string, opts *DownloadOptions) error {
+	if opts == nil {
+		opts = &DownloadOptions{}
+	}
+	partPath := path + ".part"
+	validatorPath := partPath + ".validator"
+	part, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer part.Close()
+
+	info, err := part.Stat()
+	if err != nil {
+		return err
+	}
+	offset := info.Size()
+	if offset > 0 {
+		// Copy headers so that the caller's backing array is not written.
+		headers = append(headers[:len(headers):len(headers)], [2]string{"Range", "bytes=" + strconv.FormatInt(offset, 10) + "-"})
+		if validator, err := os.ReadFile(validatorPath); err == nil && len(validator) > 0 {
+			headers = append(headers, [2]string{"If-Range", string(validator)})
+		}
+	}
+	s, err := c.GetStream(ctx, url, headers)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	total := s.ContentLength
+	switch {
+	case s.StatusCode == http.StatusPartialContent && offset > 0:
+		start, size, ok := parseContentRange(s.header.Get("Content-Range"))
+		if !ok || start != offset {
+			return fmt.Errorf("twocker: unexpected Content-Range %q for offset %d", s.header.Get("Content-Range"), offset)
+		}
+		total = size
+	case s.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// The part file may already be complete.
+		_, size, ok := parseContentRange(s.header.Get("Content-Range"))
+		if !ok || size != offset {
+			return fmt.Errorf("twocker: range not satisfiable for offset %d", offset)
+		}
+		total = size
+		_ = s.Body.Close()
+		s.Body = http.NoBody
+	case s.StatusCode >= 200 && s.StatusCode < 300:
+		// The server ignored the range or the resource changed; start over.
+		offset = 0
+		if err := part.Truncate(0); err != nil {
+			return err
+		}
+		if err := saveValidator(validatorPath, s.header); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("twocker: unexpected status %d downloading %s", s.StatusCode, url)
+	}
+
+	h := opts.newHash()
+	if h != nil && offset > 0 {
+		if _, err := io.Copy(h, io.NewSectionReader(part, 0, offset)); err != nil {
+			return err
+		}
+	}
+	if _, err := part.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	if err := writeDownload(part, s.Body, offset, total, h, opts); err != nil {
+		if errors.Is(err, ErrBodyTooLarge) || errors.Is(err, ErrChecksumMismatch) {
+			_ = part.Close()
+			_ = os.Remove(partPath)
+			_ = os.Remove(validatorPath)
+		}
+		return err
+	}
+	if err := finishFile(part, partPath, path); err != nil {
+		return err
+	}
+	if err := os.Remove(validatorPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// saveValidator stores the strong ETag or the Last-Modified date of a
+// response for If-Range, or removes the stored one when there is neither.
+func saveValidator(path string, header http.Header) error {
+	validator := header.Get("ETag")
+	if strings.HasPrefix(validator, "W/") {
+		// Weak ETags cannot be used with If-Range.
+		validator = ""
+	}
+	if validator == "" {
+		validator = header.Get("Last-Modified")
+	}
+	if validator == "" {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(path, []byte(validator), 0o644)
+}
+
+func (o *DownloadOptions) newHash() hash.Hash {
+	if o.Hash == nil {
+		return nil
+	}
+	return o.Hash()
+}
+
+// writeDownload copies src to dst after offset bytes were already written,
+// enforcing the size limit and verifying the checksum once src is drained.
+func writeDownload(dst io.Writer, src io.Reader, offset int64, total int64, h hash.Hash, opts *DownloadOptions) error {
+	if opts.MaxBodySize > 0 && total > opts.MaxBodySize {
+		return fmt.Errorf("%w: %d > %d bytes", ErrBodyTooLarge, total, opts.MaxBodySize)
+	}
+	if h != nil {
+		dst = io.MultiWriter(dst, h)
+	}
+	written := offset
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			written += int64(n)
+			if opts.MaxBodySize > 0 && written > opts.MaxBodySize {
+				return fmt.Errorf("%w: more than %d bytes", ErrBodyTooLarge, opts.MaxBodySize)
+			}
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			if opts.Progress != nil {
+				opts.Progress(written, total)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if h != nil && opts.Checksum != "" {
+		sum := hex.EncodeToString(h.Sum(nil))
+		if !strings.EqualFold(sum, opts.Checksum) {
+			return fmt.Errorf("%w: got %s, want %s", ErrChecksumMismatch, sum, opts.Checksum)
+		}
+	}
+	return nil
+}
+
+// createTemp creates a temporary file next to path with the mode of the file
+// at path, or 0644 less the umask when there is none. os.CreateTemp would
+// leave the saved file readable by its owner only.
+func createTemp(path string) (*os.File, error) {
+	perm := fs.FileMode(0o644)
+	existing, statErr := os.Stat(path)
+	if statErr == nil {
+		perm = existing.Mode().Perm()
+	}
+	for {
+		name := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+"."+strconv.FormatUint(rand.Uint64(), 36)+".tmp")
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil || statErr != nil {
+			return f, err
+		}
+		// The umask applies to new files only; keep the existing mode as is.
+		if err := f.Chmod(perm); err != nil {
+			_ = f.Close()
+			_ = os.Remove(name)
+			return nil, err
+		}
+		return f, nil
+	}
+}
+
+func finishFile(f *os.File, from string, to string) error {
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(from, to)
+}
+
+// parseContentRange parses "bytes start-end/size" and "bytes */size".
+func parseContentRange(value string) (start int64, size int64, ok bool) {
+	value, found := strings.CutPrefix(value, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	rangePart, sizePart, found := strings.Cut(value, "/")
+	if !found {
+		return 0, 0, false
+	}
+	size, err := strconv.ParseInt(sizePart, 10, 64)
+	if err != nil {
+		size = -1
+	}
+	if rangePart == "*" {
+		return 0, size, err == nil
+	}
+	startPart, _, found := strings.Cut(rangePart, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err = strconv.ParseInt(startPart, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return start, size, true
+}
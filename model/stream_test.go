@@ -0,0 +1,232 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newDownloadServer(t *testing.T, content []byte) (*httptest.Server, *[]string) {
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	t.Cleanup(server.Close)
+	return server, &ranges
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestGetStream(t *testing.T) {
+	content := bytes.Repeat([]byte("twocker"), 1000)
+	server, _ := newDownloadServer(t, content)
+
+	s, err := NewTwockerClient().GetStream(context.Background(), server.URL, nil)
+	if err != nil {
+		t.Fatalf("Error making streaming GET request: %v", err)
+	}
+	defer s.Close()
+	if s.StatusCode != http.StatusOK || s.ContentLength != int64(len(content)) {
+		t.Errorf("Unexpected stream metadata: %d, %d", s.StatusCode, s.ContentLength)
+	}
+	b, err := io.ReadAll(s.Body)
+	if err != nil || !bytes.Equal(b, content) {
+		t.Errorf("Unexpected streamed body: %v", err)
+	}
+}
+
+func TestStreamSaveToFile(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	server, _ := newDownloadServer(t, content)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.bin")
+
+	var lastWritten, lastTotal int64
+	s, err := NewTwockerClient().R().Stream(http.MethodGet, server.URL)
+	if err != nil {
+		t.Fatalf("Error making streaming GET request: %v", err)
+	}
+	err = s.SaveToFile(path, &DownloadOptions{
+		Progress: func(written int64, total int64) { lastWritten, lastTotal = written, total },
+		Hash:     sha256.New,
+		Checksum: sha256Hex(content),
+	})
+	if err != nil {
+		t.Fatalf("Error saving stream: %v", err)
+	}
+	saved, _ := os.ReadFile(path)
+	if !bytes.Equal(saved, content) {
+		t.Errorf("Saved file differs from the response body")
+	}
+	if lastWritten != int64(len(content)) || lastTotal != int64(len(content)) {
+		t.Errorf("Unexpected final progress %d/%d", lastWritten, lastTotal)
+	}
+	// A new file gets the mode of files created with 0644 under the umask.
+	reference := filepath.Join(t.TempDir(), "reference")
+	if err := os.WriteFile(reference, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := os.Stat(reference)
+	if info, _ := os.Stat(path); info.Mode().Perm() != want.Mode().Perm() {
+		t.Errorf("Expected saved file mode %v, got %v", want.Mode().Perm(), info.Mode().Perm())
+	}
+	if err := os.Chmod(path, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	s, _ = NewTwockerClient().GetStream(context.Background(), server.URL, nil)
+	if err := s.SaveToFile(path, nil); err != nil {
+		t.Fatalf("Error saving stream: %v", err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o640 {
+		t.Errorf("Expected the replaced file's mode 0640 to be kept, got %v", info.Mode().Perm())
+	}
+
+	s, _ = NewTwockerClient().GetStream(context.Background(), server.URL, nil)
+	err = s.SaveToFile(filepath.Join(dir, "bad.bin"), &DownloadOptions{Hash: sha256.New, Checksum: strings.Repeat("0", 64)})
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+	}
+	s, _ = NewTwockerClient().GetStream(context.Background(), server.URL, nil)
+	err = s.SaveToFile(filepath.Join(dir, "big.bin"), &DownloadOptions{MaxBodySize: 1000})
+	if !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("Expected ErrBodyTooLarge, got %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("Failed saves should leave no files behind, found %d entries", len(entries))
+	}
+}
+
+func TestDownloadResumes(t *testing.T) {
+	content := bytes.Repeat([]byte("abcdefghij"), 5000)
+	server, ranges := newDownloadServer(t, content)
+	path := filepath.Join(t.TempDir(), "file.bin")
+	if err := os.WriteFile(path+".part", content[:12345], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Spare capacity that the Range header must not be written into
+	headers := make([][2]string, 1, 2)
+	headers[0] = [2]string{"Accept", "*/*"}
+	err := NewTwockerClient().Download(context.Background(), server.URL, path, headers, &DownloadOptions{
+		Hash:     sha256.New,
+		Checksum: sha256Hex(content),
+	})
+	if err != nil {
+		t.Fatalf("Error resuming download: %v", err)
+	}
+	if spare := headers[:2][1]; spare != [2]string{} {
+		t.Errorf("Download should not write into the caller's headers, found %v", spare)
+	}
+	if len(*ranges) != 1 || (*ranges)[0] != "bytes=12345-" {
+		t.Errorf("Expected a single ranged request, got %v", *ranges)
+	}
+	saved, _ := os.ReadFile(path)
+	if !bytes.Equal(saved, content) {
+		t.Errorf("Resumed file differs from the original")
+	}
+	if _, err := os.Stat(path + ".part"); !os.IsNotExist(err) {
+		t.Errorf("Expected the part file to be renamed, got %v", err)
+	}
+
+	// A complete part file is finished without downloading again.
+	if err := os.WriteFile(path+".part", content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewTwockerClient().Download(context.Background(), server.URL, path, nil, nil); err != nil {
+		t.Fatalf("Error finishing complete download: %v", err)
+	}
+	saved, _ = os.ReadFile(path)
+	if !bytes.Equal(saved, content) {
+		t.Errorf("Finished file differs from the original")
+	}
+}
+
+func TestDownloadRestartsChangedResource(t *testing.T) {
+	oldContent := bytes.Repeat([]byte("old-"), 5000)
+	newContent := bytes.Repeat([]byte("new+"), 6000)
+	var requests int
+	var ifRanges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		ifRanges = append(ifRanges, r.Header.Get("If-Range"))
+		if requests == 1 {
+			// Interrupt the first download halfway.
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Content-Length", strconv.Itoa(len(oldContent)))
+			_, _ = w.Write(oldContent[:len(oldContent)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		w.Header().Set("ETag", `"v2"`)
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(newContent))
+	}))
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "file.bin")
+
+	c := NewTwockerClient()
+	if err := c.Download(context.Background(), server.URL, path, nil, nil); err == nil {
+		t.Fatal("Expected the interrupted download to fail")
+	}
+	if err := c.Download(context.Background(), server.URL, path, nil, nil); err != nil {
+		t.Fatalf("Error downloading: %v", err)
+	}
+	if len(ifRanges) != 2 || ifRanges[1] != `"v1"` {
+		t.Errorf("Expected the resumed request to send If-Range, got %q", ifRanges)
+	}
+	saved, _ := os.ReadFile(path)
+	if !bytes.Equal(saved, newContent) {
+		t.Errorf("Expected the changed resource to be downloaded again from the start")
+	}
+	if _, err := os.Stat(path + ".part.validator"); !os.IsNotExist(err) {
+		t.Errorf("Expected the validator file to be removed, got %v", err)
+	}
+}
+
+func TestDownloadMaxBodySize(t *testing.T) {
+	server, _ := newDownloadServer(t, bytes.Repeat([]byte("x"), 4096))
+	path := filepath.Join(t.TempDir(), "file.bin")
+
+	err := NewTwockerClient().Download(context.Background(), server.URL, path, nil, &DownloadOptions{MaxBodySize: 1024})
+	if !errors.Is(err, ErrBodyTooLarge) {
+		t.Fatalf("Expected ErrBodyTooLarge, got %v", err)
+	}
+	if _, err := os.Stat(path + ".part"); !os.IsNotExist(err) {
+		t.Errorf("Expected the part file to be removed, got %v", err)
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	cases := []struct {
+		value       string
+		start, size int64
+		ok          bool
+	}{
+		{"bytes 100-199/1000", 100, 1000, true},
+		{"bytes 100-199/*", 100, -1, true},
+		{"bytes */1000", 0, 1000, true},
+		{"items 1-2/3", 0, 0, false},
+		{"bytes 100/1000", 0, 0, false},
+	}
+	for _, tc := range cases {
+		start, size, ok := parseContentRange(tc.value)
+		if start != tc.start || size != tc.size || ok != tc.ok {
+			t.Errorf("parseContentRange(%q) = %d, %d, %v; want %d, %d, %v", tc.value, start, size, ok, tc.start, tc.size, tc.ok)
+		}
+	}
+}
@@ -8,11 +8,42 @@ import (
 type TwockerClient = model.TwockerClient
 type TwockerResponse = model.TwockerResponse
 type Selection = goquery.Selection
+type RequestError = model.RequestError
+type Request = model.Request
+type MultipartFile = model.MultipartFile
+type Timings = model.Timings
+type TwockerStream = model.TwockerStream
+type DownloadOptions = model.DownloadOptions
+type RetryPolicy = model.RetryPolicy
+type RateLimit = model.RateLimit
+type RateLimiter = model.RateLimiter
+type RateLimitBackend = model.RateLimitBackend
+
+var (
+	ErrBuildRequest = model.ErrBuildRequest
+	ErrTransport    = model.ErrTransport
+	ErrReadBody     = model.ErrReadBody
+	ErrTimeout      = model.ErrTimeout
+	ErrTLS          = model.ErrTLS
+	ErrRateLimit    = model.ErrRateLimit
+
+	ErrRobotsDisallowed = model.ErrRobotsDisallowed
+	ErrBodyTooLarge     = model.ErrBodyTooLarge
+	ErrChecksumMismatch = model.ErrChecksumMismatch
+)
 
 func NewTwockerClient() *model.TwockerClient {
 	return model.NewTwockerClient()
 }
 
+func DefaultRetryPolicy() *RetryPolicy {
+	return model.DefaultRetryPolicy()
+}
+
+func NewRateLimiter(defaultLimit RateLimit) *RateLimiter {
+	return model.NewRateLimiter(defaultLimit)
+}
+
 func TwockerJson[T any](r *TwockerResponse) (*T, error) {
 	return model.TwockerJson[T](r)
 }